@@ -1,8 +1,94 @@
 package constants
 
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
 const MAP_PATH = "./assets/mapa.png"
 
+// Logo of the association used in the reports
+const LOGO_PATH = "./assets/logo.png"
+
+// Warehouse whose map is MAP_PATH
+const DEFAULT_WAREHOUSE = "BENFICA"
+
+// Timezone of the warehouses, used by the scheduler and the reports
+const TIMEZONE = "Europe/Lisbon"
+
+// Days a car stays in the list after it is exported or cancelled, before it goes to the archive
+const DEFAULT_ARCHIVE_DAYS = 7
+
+// Years an archived car is kept before it is removed for good, the legal retention of the documents
+const DEFAULT_RETENTION_YEARS = 10
+
+// Days without activity after which an open car is flagged as stale
+const DEFAULT_STALE_DAYS = 3
+
+// Hours a stale car is kept, after the clients are told, before it is cancelled
+const DEFAULT_STALE_GRACE_HOURS = 24
+
 // GetMapPath returns the path to the map file.
 func GetMapPath() string {
 	return MAP_PATH
 }
+
+// GetWarehouseMapPath returns the path to the map file of a warehouse, the main warehouse uses MAP_PATH.
+func GetWarehouseMapPath(id_warehouse string) string {
+	if id_warehouse == "" || id_warehouse == DEFAULT_WAREHOUSE {
+		return MAP_PATH
+	}
+	return "./assets/mapa_" + id_warehouse + ".png"
+}
+
+// GetLocation returns the timezone of the warehouses, or UTC if it can not be loaded.
+func GetLocation() *time.Location {
+	loc, err := time.LoadLocation(TIMEZONE)
+	if err != nil {
+		fmt.Printf("Error loading timezone, using UTC: %v\n", err)
+		return time.UTC
+	}
+	return loc
+}
+
+// GetArchiveDays returns the days set in CAR_ARCHIVE_DAYS, or DEFAULT_ARCHIVE_DAYS.
+func GetArchiveDays() int {
+	return getEnvInt("CAR_ARCHIVE_DAYS", DEFAULT_ARCHIVE_DAYS, 0)
+}
+
+// GetRetentionYears returns the years set in CAR_RETENTION_YEARS, or DEFAULT_RETENTION_YEARS.
+func GetRetentionYears() int {
+	return getEnvInt("CAR_RETENTION_YEARS", DEFAULT_RETENTION_YEARS, 1)
+}
+
+// GetStaleDays returns the days set in CAR_STALE_DAYS, or DEFAULT_STALE_DAYS.
+func GetStaleDays() int {
+	return getEnvInt("CAR_STALE_DAYS", DEFAULT_STALE_DAYS, 1)
+}
+
+// GetStaleGraceHours returns the hours set in CAR_STALE_GRACE_HOURS, or DEFAULT_STALE_GRACE_HOURS.
+func GetStaleGraceHours() int {
+	return getEnvInt("CAR_STALE_GRACE_HOURS", DEFAULT_STALE_GRACE_HOURS, 0)
+}
+
+// IsStockCheckOn tells if the exports that take more than the stock are refused, STOCK_CHECK=off turns it off
+// while the opening stock is being counted.
+func IsStockCheckOn() bool {
+	return os.Getenv("STOCK_CHECK") != "off"
+}
+
+// getEnvInt reads a whole number from the environment, values below min can not be used.
+func getEnvInt(name string, fallback int, min int) int {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback
+	}
+	number, err := strconv.Atoi(value)
+	if err != nil || number < min {
+		fmt.Printf("Invalid %s %q, using %d\n", name, value, fallback)
+		return fallback
+	}
+	return number
+}
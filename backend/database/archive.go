@@ -0,0 +1,156 @@
+package database
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Most cars returned by one search of the history
+const (
+	DefaultHistoryLimit = 50
+	MaxHistoryLimit     = 500
+)
+
+// Errors of the history of the cars
+var (
+	ErrCarExported          = errors.New("car was exported, it is kept in the history and can not be deleted")
+	ErrInvalidHistoryStatus = errors.New("the history only has exported, cancelled and archived cars")
+)
+
+// Filters of the search in the history, the empty ones are not used
+// From and To (YYYY-MM-DD) are compared with the day the car was exported or cancelled
+type CarHistoryFilter struct {
+	Status         string
+	Type           string
+	IDWarehouse    string
+	From           string
+	To             string
+	DocumentNumber string
+	IDDonor        string
+	IDProduct      string
+	Limit          int
+	Offset         int
+}
+
+// Moves the cars exported or cancelled more than days ago to the archive, they leave the list of cars
+// but everything they had stays in the database. Returns how many cars were archived
+func ArchiveCars(db *pgxpool.Pool, days int) (int64, error) {
+
+	query := `
+		UPDATE cars
+		SET status = 'archived', archived_at = CURRENT_TIMESTAMP
+		WHERE (status = 'exported' AND exported_at < CURRENT_DATE - make_interval(days => $1))
+			OR (status = 'cancelled' AND cancelled_at < CURRENT_DATE - make_interval(days => $1))
+	`
+	tag, err := db.Exec(context.Background(), query, days)
+	if err != nil {
+		return 0, err
+	}
+
+	return tag.RowsAffected(), nil
+}
+
+// Removes for good the archived cars exported or cancelled more than years ago, with their products and
+// inventory differences. A car archived without the day it was cancelled counts from the day it was archived
+// The stock movements are never removed, the ledger is append-only
+// Returns how many cars were removed
+func PurgeArchivedCars(db *pgxpool.Pool, years int) (int64, error) {
+
+	ctx := context.Background()
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	query := `
+		CREATE TEMP TABLE purged_cars ON COMMIT DROP AS
+		SELECT id_car
+		FROM cars
+		WHERE status = 'archived'
+			AND COALESCE(exported_at, cancelled_at, archived_at) < CURRENT_DATE - make_interval(years => $1)
+	`
+	if _, err = tx.Exec(ctx, query, years); err != nil {
+		return 0, err
+	}
+
+	// The products first, they reference the cars
+	for _, table := range []string{"products_car", "inventory_variances", "inventory_approvals"} {
+		if _, err = tx.Exec(ctx, `DELETE FROM `+table+` WHERE id_car IN (SELECT id_car FROM purged_cars)`); err != nil {
+			return 0, err
+		}
+	}
+
+	tag, err := tx.Exec(ctx, `DELETE FROM cars WHERE id_car IN (SELECT id_car FROM purged_cars)`)
+	if err != nil {
+		return 0, err
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
+
+// Searches the exported, cancelled and archived cars, the most recent first
+// The cars come without products, GetCar gives the products of each one
+func SearchCarHistory(db *pgxpool.Pool, filter CarHistoryFilter) ([]Car, error) {
+
+	switch filter.Status {
+	case "", CarStatusExported, CarStatusCancelled, CarStatusArchived:
+	default:
+		return nil, ErrInvalidHistoryStatus
+	}
+	if filter.Limit <= 0 {
+		filter.Limit = DefaultHistoryLimit
+	}
+	if filter.Limit > MaxHistoryLimit {
+		filter.Limit = MaxHistoryLimit
+	}
+	if filter.Offset < 0 {
+		filter.Offset = 0
+	}
+
+	query := `
+		SELECT ` + carColumns + `
+		FROM cars
+		WHERE status IN ('exported', 'cancelled', 'archived')
+			AND ($1 = '' OR status = $1)
+			AND ($2 = '' OR type = $2)
+			AND ($3 = '' OR id_warehouse = $3 OR id_warehouse_dest = $3)
+			AND ($4 = '' OR COALESCE(exported_at, cancelled_at) >= NULLIF($4, '')::date)
+			AND ($5 = '' OR COALESCE(exported_at, cancelled_at) < NULLIF($5, '')::date + INTERVAL '1 day')
+			AND ($6 = '' OR document_number = $6)
+			AND ($7 = '' OR id_donor = $7)
+			AND ($8 = '' OR EXISTS (
+				SELECT 1 FROM products_car pc WHERE pc.id_car = cars.id_car AND pc.id_product = $8
+			))
+		ORDER BY COALESCE(exported_at, cancelled_at) DESC, id_car
+		LIMIT $9 OFFSET $10
+	`
+
+	rows, err := db.Query(context.Background(), query,
+		filter.Status, filter.Type, filter.IDWarehouse, filter.From, filter.To,
+		filter.DocumentNumber, filter.IDDonor, filter.IDProduct, filter.Limit, filter.Offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	cars := []Car{}
+	for rows.Next() {
+		var car Car
+		if err := scanCar(rows, &car); err != nil {
+			return nil, err
+		}
+		cars = append(cars, car)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return cars, nil
+}
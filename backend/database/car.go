@@ -2,20 +2,90 @@ package database
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"log"
-	"math/rand"
+	"math/big"
 	"time"
 
+	"github.com/Samuel-k276/backend/constants"
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
 // Struct of a car -- that thing with json allow us to receive well the info from the DB
 type Car struct {
-	ID         string        `json:"id_car"`
-	Type       string        `json:"type"`
-	DateExport string        `json:"date_export"`
-	Products   []Car_Product `json:"products"`
+	ID              string        `json:"id_car"`
+	Type            string        `json:"type"`
+	Subtype         string        `json:"subtype"`
+	IDWarehouse     string        `json:"id_warehouse"`
+	IDWarehouseDest string        `json:"id_warehouse_dest"`
+	DateExport      string        `json:"date_export"`
+	Status          string        `json:"status"`
+	CreatedAt       *time.Time    `json:"created_at"`
+	OpenedAt        *time.Time    `json:"opened_at"`
+	LockedAt        *time.Time    `json:"locked_at"`
+	ExportedAt      *time.Time    `json:"exported_at"`
+	CancelledAt     *time.Time    `json:"cancelled_at"`
+	ArchivedAt      *time.Time    `json:"archived_at"`
+	DocumentNumber  string        `json:"document_number"`
+	LastActivityAt  *time.Time    `json:"last_activity_at"`
+	StaleAt         *time.Time    `json:"stale_at"`
+	Version         int           `json:"version"`
+	Products        []Car_Product `json:"products"`
+
+	// Names of the warehouses, kept when the car is exported, before that they come from the warehouses table
+	WarehouseName     string `json:"warehouse_name"`
+	WarehouseDestName string `json:"warehouse_dest_name"`
+
+	// Information of the export form, it is sent in the same object as the rest of the car
+	CarMetadata
+
+	// Only filled when the car is created, it is what gives access to the car
+	AccessToken string `json:"access_token,omitempty"`
+}
+
+// Columns of the cars table read into the Car struct, in the order used by scanCar
+const carColumns = `id_car, type, subtype, id_warehouse, id_warehouse_dest, date_export,
+	status, created_at, opened_at, locked_at, exported_at, cancelled_at, archived_at,
+	COALESCE(document_number, ''), last_activity_at, stale_at, version,
+	COALESCE(cars.warehouse_name, (SELECT w.name FROM warehouses w WHERE w.id_warehouse = cars.id_warehouse), cars.id_warehouse),
+	COALESCE(cars.warehouse_dest_name, (SELECT w.name FROM warehouses w WHERE w.id_warehouse = cars.id_warehouse_dest), cars.id_warehouse_dest),
+	` + carMetadataColumns
+
+// Reads a row with the carColumns into the car
+func scanCar(row pgx.Row, car *Car) error {
+	return row.Scan(
+		&car.ID,
+		&car.Type,
+		&car.Subtype,
+		&car.IDWarehouse,
+		&car.IDWarehouseDest,
+		&car.DateExport,
+		&car.Status,
+		&car.CreatedAt,
+		&car.OpenedAt,
+		&car.LockedAt,
+		&car.ExportedAt,
+		&car.CancelledAt,
+		&car.ArchivedAt,
+		&car.DocumentNumber,
+		&car.LastActivityAt,
+		&car.StaleAt,
+		&car.Version,
+		&car.WarehouseName,
+		&car.WarehouseDestName,
+		&car.IDDonor,
+		&car.DonorName,
+		&car.CountedBy,
+		&car.Recipient,
+		&car.PerformedBy,
+		&car.Reason,
+		&car.DocumentDate,
+	)
 }
 
 // Struct of a product in the car we need more things because of the need to send to the frontend
@@ -31,6 +101,28 @@ type Car_Product struct {
 	Quantity    float64 `json:"quantity"`
 	Expiration  string  `json:"expiration"`
 	Description string  `json:"description"`
+	Reason      string  `json:"reason"`
+	Version     int     `json:"version"`
+}
+
+// Types of car accepted by the system
+const (
+	CarTypeEntrada       = "Entrada"
+	CarTypeSaida         = "Saída"
+	CarTypeInventario    = "Inventário"
+	CarTypeTransferencia = "Transferência"
+)
+
+// Warehouse of the cars and movements that do not say which one they belong to
+const DefaultWarehouse = constants.DEFAULT_WAREHOUSE
+
+// Checks if the type of car exists
+func IsValidCarType(car_type string) bool {
+	switch car_type {
+	case CarTypeEntrada, CarTypeSaida, CarTypeInventario, CarTypeTransferencia:
+		return true
+	}
+	return false
 }
 
 // This Part is to only the car as a whole not the products inside
@@ -38,57 +130,167 @@ type Car_Product struct {
 // Character set used to generate the random car ID
 const charset = "ABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
 
-// Function to generate a random 6-character code for the car ID
-func GenerateRandomCode() string {
+// Length of the car ID, the volunteers type it so it stays short, the access token is what protects the car
+const CarCodeLength = 6
+
+// Number of bytes of randomness in the access token of a car
+const accessTokenBytes = 32
 
-	rand.Seed(time.Now().UnixNano())
-	code := make([]byte, 6)
+// Number of times a new ID is tried when the generated one is already in use
+const maxCarIDAttempts = 10
+
+// Error returned when every generated ID was already in use
+var ErrCarIDUnavailable = errors.New("could not find a free id for the car")
+
+// Function to generate a random code for the car ID
+func GenerateRandomCode() (string, error) {
+
+	code := make([]byte, CarCodeLength)
+	max := big.NewInt(int64(len(charset)))
 
 	for i := range code {
-		code[i] = charset[rand.Intn(len(charset))]
+		// rand.Int gives every character the same chance
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		code[i] = charset[n.Int64()]
 	}
-	return string(code)
+	return string(code), nil
 }
 
-// Create car function
-func CreateCar(db *pgxpool.Pool, cart_type string) (*Car, error) {
+// Function to generate the secret token that gives access to a car
+func GenerateAccessToken() (string, error) {
 
-	id_car := GenerateRandomCode()
+	token := make([]byte, accessTokenBytes)
+	if _, err := rand.Read(token); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(token), nil
+}
 
-	// Insert the new car changes the $1, $2 with the id_car and cart_type
-	insertQuery := `
-		INSERT INTO cars (id_car, type) 
-		VALUES ($1, $2)
-	`
-	_, err := db.Exec(context.Background(), insertQuery, id_car, cart_type)
+// Create car function, the subtype is only used by Saída cars and the destination only by Transferência cars
+// The returned car is the only one that has the access token
+func CreateCar(db *pgxpool.Pool, cart_type string, subtype string, id_warehouse string, id_warehouse_dest string) (*Car, error) {
 
+	access_token, err := GenerateAccessToken()
 	if err != nil {
 		return nil, err
 	}
 
-	// Retrieve the created car with the date_export value
-	selectQuery := `
-		SELECT id_car, type, date_export 
-		FROM cars 
+	// Insert the new car changes the $1 ... $6 with the id_car, cart_type, subtype, warehouses and token
+	// A car that already has that id is left alone and nothing is returned
+	insertQuery := `
+		INSERT INTO cars (id_car, type, subtype, id_warehouse, id_warehouse_dest, access_token)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (id_car) DO NOTHING
+		RETURNING ` + carColumns + `
+	`
+
+	for attempt := 0; attempt < maxCarIDAttempts; attempt++ {
+		id_car, err := GenerateRandomCode()
+		if err != nil {
+			return nil, err
+		}
+
+		var car Car
+		row := db.QueryRow(context.Background(), insertQuery, id_car, cart_type, subtype, id_warehouse, id_warehouse_dest, access_token)
+		err = scanCar(row, &car)
+		if err == pgx.ErrNoRows {
+			// The id was already in use, try another
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		car.AccessToken = access_token
+		return &car, nil
+	}
+
+	return nil, ErrCarIDUnavailable
+}
+
+// Checks if the token is the access token of the car
+func CheckCarAccess(db *pgxpool.Pool, id_car string, token string) (bool, error) {
+
+	query := `
+		SELECT access_token
+		FROM cars
 		WHERE id_car = $1
 	`
-	row := db.QueryRow(context.Background(), selectQuery, id_car)
+	var access_token string
+	err := db.QueryRow(context.Background(), query, id_car).Scan(&access_token)
+	if err == pgx.ErrNoRows {
+		return false, ErrCarNotFound
+	}
+	if err != nil {
+		return false, err
+	}
 
-	var car Car
-	err = row.Scan(&car.ID, &car.Type, &car.DateExport)
+	// Compared in constant time so the token can not be found by timing the answers
+	if access_token == "" || token == "" {
+		return false, nil
+	}
+	return subtle.ConstantTimeCompare([]byte(access_token), []byte(token)) == 1, nil
+}
+
+// Gives an access token to the cars that do not have one
+func backfillAccessTokens(db *pgxpool.Pool) error {
+
+	query := `
+		SELECT id_car
+		FROM cars
+		WHERE access_token = ''
+	`
+	rows, err := db.Query(context.Background(), query)
 	if err != nil {
-		return nil, err
+		return err
+	}
+	ids, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return err
 	}
 
-	return &car, nil
+	query = `
+		UPDATE cars
+		SET access_token = $1
+		WHERE id_car = $2 AND access_token = ''
+	`
+	for _, id_car := range ids {
+		access_token, err := GenerateAccessToken()
+		if err != nil {
+			return err
+		}
+		if _, err = db.Exec(context.Background(), query, access_token, id_car); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Gets only the type of the car
+func GetCarType(db *pgxpool.Pool, id_car string) (string, error) {
+
+	query := `
+		SELECT type
+		FROM cars
+		WHERE id_car = $1
+	`
+	var car_type string
+	err := db.QueryRow(context.Background(), query, id_car).Scan(&car_type)
+
+	return car_type, err
 }
 
-// GetAllCars retrieves all cars from the database
+// GetAllCars retrieves all cars from the database, except the archived ones
 func GetAllCars(db *pgxpool.Pool) ([]Car, error) {
-	// Query to get all cars
+	// Query to get all cars, the archived ones are in the history
 	query := `
-		SELECT id_car, type, date_export 
+		SELECT ` + carColumns + `
 		FROM cars
+		WHERE status <> 'archived'
 	`
 
 	rows, err := db.Query(context.Background(), query)
@@ -100,14 +302,14 @@ func GetAllCars(db *pgxpool.Pool) ([]Car, error) {
 	var cars []Car
 	for rows.Next() {
 		var car Car
-		err := rows.Scan(&car.ID, &car.Type, &car.DateExport)
+		err := scanCar(rows, &car)
 		if err != nil {
 			return nil, err
 		}
 
 		// Get products for each car
 		productsQuery := `
-			SELECT id, id_product, quantity, expiration, description
+			SELECT id, id_product, quantity, expiration, description, reason
 			FROM products_car
 			WHERE id_car = $1
 		`
@@ -120,7 +322,7 @@ func GetAllCars(db *pgxpool.Pool) ([]Car, error) {
 		var products []Car_Product
 		for productRows.Next() {
 			var product Car_Product
-			err := productRows.Scan(&product.ID, &product.IDProduct, &product.Quantity, &product.Expiration, &product.Description)
+			err := productRows.Scan(&product.ID, &product.IDProduct, &product.Quantity, &product.Expiration, &product.Description, &product.Reason)
 			if err != nil {
 				return nil, err
 			}
@@ -147,12 +349,12 @@ func GetAllCars(db *pgxpool.Pool) ([]Car, error) {
 func GetCar(db *pgxpool.Pool, id_car string) (*Car, error) {
 	// Query to retrieve the car by ID
 	query := `
-		SELECT id_car, type, date_export 
+		SELECT ` + carColumns + `
 		FROM cars 
 		WHERE id_car = $1
 	`
 	var car Car
-	err := db.QueryRow(context.Background(), query, id_car).Scan(&car.ID, &car.Type, &car.DateExport)
+	err := scanCar(db.QueryRow(context.Background(), query, id_car), &car)
 	if err != nil {
 		return nil, err
 	}
@@ -162,13 +364,15 @@ func GetCar(db *pgxpool.Pool, id_car string) (*Car, error) {
 		SELECT 
 			pc.id,
 			pc.id_product,
-			p.name,
-			p.unit,
+			COALESCE(pc.name, p.name),
+			COALESCE(pc.unit, p.unit),
 			p.pos_x,
 			p.pos_y,
 			pc.quantity,
 			pc.expiration,
-			pc.description
+			pc.description,
+			pc.reason,
+			pc.version
 		FROM products_car pc
 		JOIN products p ON pc.id_product = p.id_product
 		WHERE pc.id_car = $1
@@ -193,6 +397,8 @@ func GetCar(db *pgxpool.Pool, id_car string) (*Car, error) {
 			&product.Quantity,
 			&product.Expiration,
 			&product.Description,
+			&product.Reason,
+			&product.Version,
 		)
 		if err != nil {
 			return nil, err
@@ -207,93 +413,137 @@ func GetCar(db *pgxpool.Pool, id_car string) (*Car, error) {
 	return &car, nil
 }
 
-// Delete car by id
+// Delete car by id, only cars that were never exported, the exported ones stay in the history
 func DeleteCarId(db *pgxpool.Pool, id_car string) error {
 
-	// Query to delete the cars
+	ctx := context.Background()
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	var exported bool
 	query := `
-		DELETE FROM cars
+		SELECT exported_at IS NOT NULL
+		FROM cars
 		WHERE id_car = $1
+		FOR UPDATE
 	`
-	_, err := db.Exec(context.Background(), query, id_car)
+	err = tx.QueryRow(ctx, query, id_car).Scan(&exported)
+	if err == pgx.ErrNoRows {
+		return ErrCarNotFound
+	}
 	if err != nil {
 		return err
 	}
+	if exported {
+		return ErrCarExported
+	}
 
-	// Delete the products from those cars
-	query = `
-		DELETE FROM products_car
-		WHERE id_car NOT IN (SELECT id_car FROM cars);
-	`
-	_, err = db.Exec(context.Background(), query)
-	if err != nil {
+	// Delete the products of the car first, they reference it
+	if _, err = tx.Exec(ctx, `DELETE FROM products_car WHERE id_car = $1`, id_car); err != nil {
+		return err
+	}
+	if _, err = tx.Exec(ctx, `DELETE FROM cars WHERE id_car = $1`, id_car); err != nil {
 		return err
 	}
 
-	return nil
+	return tx.Commit(ctx)
 }
 
-// Delete car if the date of the exportation 1 week ago
-func DeleteCars(db *pgxpool.Pool) error {
+// When the User clicks on exporting the time of the car changes to the current date
+// and its products are posted to the stock ledger in the same transaction
+// Cars that take stock are rejected if the stock does not cover them, unless allowNegative is true
+func ChangeDateCar(db *pgxpool.Pool, id_car string, allowNegative bool) error {
 
-	// Query to delete the cars (PostgreSQL syntax)
-	query := `
-		DELETE FROM cars
-		WHERE date_export != '0'
-			AND DATE(date_export) < CURRENT_DATE - INTERVAL '7 days';
-	`
-	_, err := db.Exec(context.Background(), query)
+	ctx := context.Background()
+	tx, err := db.Begin(ctx)
 	if err != nil {
 		return err
 	}
+	defer tx.Rollback(ctx)
 
-	// Delete the products from those cars
-	query = `
-		DELETE FROM products_car
-		WHERE id_car NOT IN (SELECT id_car FROM cars);
-	`
-	_, err = db.Exec(context.Background(), query)
+	// A car can only be exported once, from open or locked
+	car, err := transitionCar(ctx, tx, id_car, CarStatusExported)
 	if err != nil {
 		return err
 	}
 
-	return nil
-}
-
-// When the User clicks on exporting the time of the car changes to the current date
-func ChangeDateCar(db *pgxpool.Pool, id_car string) error {
-
-	// SQL query to find the car with that id
+	// The date is still kept for the clients that read it
 	query := `
-		UPDATE cars 
+		UPDATE cars
 		SET date_export = CURRENT_TIMESTAMP
 		WHERE id_car = $1
 	`
+	if _, err = tx.Exec(ctx, query, id_car); err != nil {
+		return err
+	}
+
+	// The names of the products and donor stay as they are now
+	if err = snapshotCarNames(ctx, tx, id_car); err != nil {
+		return err
+	}
+
+	// Write-offs need a reason in every line
+	if car.Type == CarTypeSaida {
+		if err = checkWriteOffReasons(ctx, tx, id_car, car.Subtype); err != nil {
+			return err
+		}
+	}
 
-	// Execute the query to change the date
-	_, err := db.Exec(context.Background(), query, id_car)
+	// Nothing can leave the warehouse if it is not there, unless the opening stock is still being counted
+	if (car.Type == CarTypeSaida || car.Type == CarTypeTransferencia) && !allowNegative && constants.IsStockCheckOn() {
+		if err = checkStockAvailable(ctx, tx, car); err != nil {
+			return err
+		}
+	}
+
+	// An inventory only touches the ledger after the admin approves the variances
+	if car.Type == CarTypeInventario {
+		err = saveInventoryVariances(ctx, tx, car)
+	} else {
+		// Register what entered or left the warehouses
+		err = postCarMovements(ctx, tx, car)
+	}
 	if err != nil {
 		return err
 	}
 
-	return nil
+	// The number is given last, so the counter is locked for as little time as possible
+	if err = assignDocumentNumber(ctx, tx, car); err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
 }
 
 // Now this part is about the products in the car
 
-// This function add products to the car with that id
-func AddProductCar(db *pgxpool.Pool, id_car string, id_product string, quantity float64, expiration string, description string) (*Car_Product, error) {
+// This function add products to the car with that id, only while the car is open
+// A line with the same product, expiration and reason gets the quantity instead of a new line
+func AddProductCar(db *pgxpool.Pool, id_car string, id_product string, quantity float64, expiration string, description string, reason string) (*Car_Product, error) {
 
-	// Query to add the Product to the car
-	query := `
-		INSERT INTO products_car (id_car, id_product, quantity, expiration, description)
-		VALUES ($1, $2, $3, $4, $5)
-		RETURNING id
-	`
+	if err := checkLineReason(reason); err != nil {
+		return nil, err
+	}
+
+	line := Car_Product{
+		IDCar:       id_car,
+		IDProduct:   id_product,
+		Quantity:    quantity,
+		Expiration:  expiration,
+		Description: description,
+		Reason:      reason,
+	}
 
-	// Query to add products to the car and get the returned ID
+	// Adding the product to the car and getting the ID of its line
 	var prod Car_Product
-	err := db.QueryRow(context.Background(), query, id_car, id_product, quantity, expiration, description).Scan(&prod.ID)
+	err := withOpenCar(db, id_car, func(ctx context.Context, tx pgx.Tx) error {
+		var err error
+		prod.ID, err = addOrMergeLine(ctx, tx, id_car, line)
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -301,35 +551,77 @@ func AddProductCar(db *pgxpool.Pool, id_car string, id_product string, quantity
 	return &prod, nil
 }
 
-// This function removes products using their id
-func DeleteProductCar(db *pgxpool.Pool, id int) error {
+// This function adds several lines to the car in one transaction, so either all of them are added or none
+// Each line is merged with the line of the same product, expiration and reason like in AddProductCar
+func AddProductCarLines(db *pgxpool.Pool, id_car string, lines []Car_Product) error {
+
+	for _, line := range lines {
+		if err := checkLineReason(line.Reason); err != nil {
+			return err
+		}
+	}
 
-	// SQL query to delete cars with date_export '0' or older than yesterday
+	return withOpenCar(db, id_car, func(ctx context.Context, tx pgx.Tx) error {
+		for _, line := range lines {
+			if _, err := addOrMergeLine(ctx, tx, id_car, line); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// This function removes products of an open car using their id, only if the line is still in that version
+func DeleteProductCar(db *pgxpool.Pool, id_car string, id int, version int) error {
+
+	// SQL query to delete the line, only if it belongs to the car
 	query := `
 		DELETE FROM products_car
-		WHERE id = $1;
+		WHERE id = $1 AND id_car = $2 AND version = $3;
 	`
 
-	// Execute the deletion query
-	_, err := db.Exec(context.Background(), query, id)
-
-	return err
+	// Execute the deletion query, nothing deleted means someone changed or removed the line before
+	return withOpenCar(db, id_car, func(ctx context.Context, tx pgx.Tx) error {
+		tag, err := tx.Exec(ctx, query, id, id_car, version)
+		if err != nil {
+			return err
+		}
+		if tag.RowsAffected() == 0 {
+			return ErrLineConflict
+		}
+		return nil
+	})
 }
 
-// This function edits the info about the car product with that id
-func EditProductCar(db *pgxpool.Pool, id int, quantity float64, expiration string, description string) error {
+// This function edits the info about the car product with that id, only while the car is open
+// and only if the line is still in the version the user edited
+func EditProductCar(db *pgxpool.Pool, id_car string, id int, version int, quantity float64, expiration string, description string, reason string) error {
+
+	if err := checkLineReason(reason); err != nil {
+		return err
+	}
 
 	// SQL query that updates the info of the product
 	query := `
 		UPDATE products_car
-		SET quantity = $1, description = $2, expiration = $3
-		WHERE id = $4;
+		SET quantity = $1, description = $2, expiration = $3, reason = $4, version = version + 1
+		WHERE id = $5 AND id_car = $6 AND version = $7
+		RETURNING id_product
 	`
 
-	// Executing the query
-	_, err := db.Exec(context.Background(), query, quantity, description, expiration, id)
-
-	return err
+	// Executing the query, the line can now be equal to another line of the product
+	return withOpenCar(db, id_car, func(ctx context.Context, tx pgx.Tx) error {
+		var id_product string
+		err := tx.QueryRow(ctx, query, quantity, description, expiration, reason, id, id_car, version).Scan(&id_product)
+		if err == pgx.ErrNoRows {
+			return ErrLineConflict
+		}
+		if err != nil {
+			return err
+		}
+		_, err = mergeCarLines(ctx, tx, id_car, id_product)
+		return err
+	})
 }
 
 // This function gets all the products by the car id
@@ -337,7 +629,7 @@ func GetCartByID(db *pgxpool.Pool, ID string) ([]Car_Product, error) {
 
 	// Query to get the products in the car
 	query := `
-		SELECT id, id_car, id_product, quantity, expiration, description
+		SELECT id, id_car, id_product, quantity, expiration, description, reason
 		FROM products_car
 		WHERE id_car = $1
 	`
@@ -364,6 +656,7 @@ func GetCartByID(db *pgxpool.Pool, ID string) ([]Car_Product, error) {
 			&item.Quantity,
 			&item.Expiration,
 			&item.Description,
+			&item.Reason,
 		)
 
 		if err != nil {
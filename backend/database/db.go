@@ -20,7 +20,7 @@ func GetDB() *pgxpool.Pool {
 // Function that creates all the tables needed
 func CreateTables() {
 
-	// This query creates the table "carrinhos", "produtos_carrinho", "produtos"
+	// This query creates the table "armazens", "carrinhos", "produtos_carrinho", "produtos" and the stock ledger
 	query := `
 	
 	CREATE TABLE IF NOT EXISTS products (
@@ -40,6 +40,18 @@ func CreateTables() {
 		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 	);
 
+	CREATE TABLE IF NOT EXISTS warehouses (
+		id_warehouse TEXT PRIMARY KEY,
+		name TEXT NOT NULL,
+		normalized_name TEXT NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	);
+
+	-- The warehouse that existed before there were more, every old car and movement belongs to it
+	INSERT INTO warehouses (id_warehouse, name, normalized_name)
+	VALUES ('BENFICA', 'Benfica', 'benfica')
+	ON CONFLICT (id_warehouse) DO NOTHING;
+
 	CREATE TABLE IF NOT EXISTS cars (
 		id_car TEXT PRIMARY KEY,
 		type TEXT NOT NULL,
@@ -57,6 +69,98 @@ func CreateTables() {
 		FOREIGN KEY (id_car) REFERENCES cars(id_car),
 		FOREIGN KEY (id_product) REFERENCES products(id_product)
 	);
+
+	CREATE TABLE IF NOT EXISTS stock_movements (
+		id SERIAL PRIMARY KEY,
+		id_car TEXT NOT NULL,
+		id_product TEXT NOT NULL,
+		quantity REAL NOT NULL,
+		expiration TEXT NOT NULL,
+		type TEXT NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+
+		FOREIGN KEY (id_product) REFERENCES products(id_product)
+	);
+
+	CREATE TABLE IF NOT EXISTS inventory_variances (
+		id SERIAL PRIMARY KEY,
+		id_car TEXT NOT NULL,
+		id_product TEXT NOT NULL,
+		expiration TEXT NOT NULL,
+		expected REAL NOT NULL,
+		counted REAL NOT NULL,
+		difference REAL NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+
+		FOREIGN KEY (id_product) REFERENCES products(id_product)
+	);
+
+	CREATE TABLE IF NOT EXISTS inventory_approvals (
+		id_car TEXT PRIMARY KEY,
+		approved_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	);
+
+	-- Columns added after the first version of the tables
+	ALTER TABLE cars ADD COLUMN IF NOT EXISTS subtype TEXT NOT NULL DEFAULT '';
+	ALTER TABLE products_car ADD COLUMN IF NOT EXISTS reason TEXT NOT NULL DEFAULT '';
+	ALTER TABLE stock_movements ADD COLUMN IF NOT EXISTS subtype TEXT NOT NULL DEFAULT '';
+	ALTER TABLE stock_movements ADD COLUMN IF NOT EXISTS reason TEXT NOT NULL DEFAULT '';
+	ALTER TABLE cars ADD COLUMN IF NOT EXISTS id_warehouse TEXT NOT NULL DEFAULT 'BENFICA' REFERENCES warehouses(id_warehouse);
+	ALTER TABLE cars ADD COLUMN IF NOT EXISTS id_warehouse_dest TEXT NOT NULL DEFAULT '';
+	ALTER TABLE stock_movements ADD COLUMN IF NOT EXISTS id_warehouse TEXT NOT NULL DEFAULT 'BENFICA' REFERENCES warehouses(id_warehouse);
+	ALTER TABLE inventory_variances ADD COLUMN IF NOT EXISTS id_warehouse TEXT NOT NULL DEFAULT 'BENFICA';
+	ALTER TABLE products ADD COLUMN IF NOT EXISTS min_quantity REAL NOT NULL DEFAULT 0;
+	ALTER TABLE products ADD COLUMN IF NOT EXISTS target_quantity REAL NOT NULL DEFAULT 0;
+	ALTER TABLE cars ADD COLUMN IF NOT EXISTS status TEXT NOT NULL DEFAULT 'open'
+		CHECK (status IN ('open', 'locked', 'exported', 'cancelled', 'archived'));
+	ALTER TABLE cars ADD COLUMN IF NOT EXISTS created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP;
+	ALTER TABLE cars ADD COLUMN IF NOT EXISTS opened_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP;
+	ALTER TABLE cars ADD COLUMN IF NOT EXISTS locked_at TIMESTAMP;
+	ALTER TABLE cars ADD COLUMN IF NOT EXISTS exported_at TIMESTAMP;
+	ALTER TABLE cars ADD COLUMN IF NOT EXISTS cancelled_at TIMESTAMP;
+	ALTER TABLE cars ADD COLUMN IF NOT EXISTS archived_at TIMESTAMP;
+	ALTER TABLE cars ADD COLUMN IF NOT EXISTS access_token TEXT NOT NULL DEFAULT '';
+	ALTER TABLE cars ADD COLUMN IF NOT EXISTS id_donor TEXT REFERENCES donors(id_donor);
+	ALTER TABLE cars ADD COLUMN IF NOT EXISTS counted_by TEXT NOT NULL DEFAULT '';
+	ALTER TABLE cars ADD COLUMN IF NOT EXISTS recipient TEXT NOT NULL DEFAULT '';
+	ALTER TABLE cars ADD COLUMN IF NOT EXISTS performed_by TEXT NOT NULL DEFAULT '';
+	ALTER TABLE cars ADD COLUMN IF NOT EXISTS reason TEXT NOT NULL DEFAULT '';
+	ALTER TABLE cars ADD COLUMN IF NOT EXISTS document_date DATE;
+	ALTER TABLE cars ADD COLUMN IF NOT EXISTS donor_name TEXT;
+	ALTER TABLE cars ADD COLUMN IF NOT EXISTS warehouse_name TEXT;
+	ALTER TABLE cars ADD COLUMN IF NOT EXISTS warehouse_dest_name TEXT;
+	ALTER TABLE products_car ADD COLUMN IF NOT EXISTS name TEXT;
+	ALTER TABLE products_car ADD COLUMN IF NOT EXISTS unit TEXT;
+	ALTER TABLE cars ADD COLUMN IF NOT EXISTS document_number TEXT UNIQUE;
+	ALTER TABLE cars ADD COLUMN IF NOT EXISTS last_activity_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP;
+	ALTER TABLE cars ADD COLUMN IF NOT EXISTS stale_at TIMESTAMP;
+	ALTER TABLE cars ADD COLUMN IF NOT EXISTS version INTEGER NOT NULL DEFAULT 1;
+	ALTER TABLE products_car ADD COLUMN IF NOT EXISTS version INTEGER NOT NULL DEFAULT 1;
+
+	-- Last document number given to each type of car in each year
+	CREATE TABLE IF NOT EXISTS document_sequences (
+		prefix TEXT NOT NULL,
+		year INTEGER NOT NULL,
+		last_number INTEGER NOT NULL,
+		PRIMARY KEY (prefix, year)
+	);
+
+	-- Cars exported before the status existed only had the date of the export
+	UPDATE cars
+	SET status = 'exported', exported_at = date_export::timestamp
+	WHERE status = 'open' AND date_export <> '0';
+
+	-- The ledger is append-only, a movement can never be changed or removed
+	CREATE OR REPLACE FUNCTION stock_movements_immutable() RETURNS trigger AS $$
+	BEGIN
+		RAISE EXCEPTION 'stock_movements is append-only';
+	END;
+	$$ LANGUAGE plpgsql;
+
+	DROP TRIGGER IF EXISTS stock_movements_immutable ON stock_movements;
+	CREATE TRIGGER stock_movements_immutable
+		BEFORE UPDATE OR DELETE ON stock_movements
+		FOR EACH ROW EXECUTE FUNCTION stock_movements_immutable();
 	`
 	// Executing the query on the DB
 	_, err := db.Exec(context.Background(), query)
@@ -65,6 +169,12 @@ func CreateTables() {
 		log.Fatalf("Error Creating the Tables: %v", err)
 	}
 
+	// Cars created before the access tokens existed get one
+	err = backfillAccessTokens(db)
+	if err != nil {
+		log.Fatalf("Error Creating the Access Tokens of the Cars: %v", err)
+	}
+
 	// Add demo products
 	err = AddDemoProducts(db)
 	if err != nil {
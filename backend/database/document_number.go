@@ -0,0 +1,54 @@
+package database
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Samuel-k276/backend/constants"
+	"github.com/jackc/pgx/v5"
+)
+
+// Prefix of the document number of each type of car, the other types do not get a number
+var documentPrefixes = map[string]string{
+	CarTypeEntrada: "E",
+	CarTypeSaida:   "S",
+}
+
+// Writes the document number the way it is printed, like E-2026/000123
+func FormatDocumentNumber(prefix string, year int, number int) string {
+	return fmt.Sprintf("%s-%d/%06d", prefix, year, number)
+}
+
+// Function that gives the next document number of the type and year of the export to the car
+// The counter row stays locked until the transaction ends, so two exports of the same type wait
+// for each other, and if the export fails the counter goes back with it, leaving no gaps
+func assignDocumentNumber(ctx context.Context, tx pgx.Tx, car *Car) error {
+
+	prefix, ok := documentPrefixes[car.Type]
+	if !ok || car.ExportedAt == nil {
+		return nil
+	}
+	// The year is the one of the date printed in the report
+	year := car.ExportedAt.In(constants.GetLocation()).Year()
+
+	query := `
+		INSERT INTO document_sequences (prefix, year, last_number)
+		VALUES ($1, $2, 1)
+		ON CONFLICT (prefix, year) DO UPDATE
+		SET last_number = document_sequences.last_number + 1
+		RETURNING last_number
+	`
+
+	var number int
+	if err := tx.QueryRow(ctx, query, prefix, year).Scan(&number); err != nil {
+		return err
+	}
+
+	documentNumber := FormatDocumentNumber(prefix, year, number)
+	if _, err := tx.Exec(ctx, `UPDATE cars SET document_number = $2 WHERE id_car = $1`, car.ID, documentNumber); err != nil {
+		return err
+	}
+
+	car.DocumentNumber = documentNumber
+	return nil
+}
@@ -0,0 +1,23 @@
+package database
+
+import "testing"
+
+func TestFormatDocumentNumber(t *testing.T) {
+	tests := []struct {
+		prefix string
+		year   int
+		number int
+		want   string
+	}{
+		{"E", 2026, 1, "E-2026/000001"},
+		{"S", 2026, 123, "S-2026/000123"},
+		{"E", 2025, 999999, "E-2025/999999"},
+		{"S", 2027, 1234567, "S-2027/1234567"},
+	}
+
+	for _, test := range tests {
+		if got := FormatDocumentNumber(test.prefix, test.year, test.number); got != test.want {
+			t.Errorf("FormatDocumentNumber(%q, %d, %d) = %q, want %q", test.prefix, test.year, test.number, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Struct of a line of an exported car with the information of the car, used by the spreadsheets
+type ExportedCarLine struct {
+	IDCar          string    `json:"id_car"`
+	DocumentNumber string    `json:"document_number"`
+	Type           string    `json:"type"`
+	Subtype        string    `json:"subtype"`
+	Warehouse      string    `json:"id_warehouse"`
+	ExportedAt     time.Time `json:"exported_at"`
+	DocumentDate   string    `json:"document_date"`
+	DonorName      string    `json:"donor_name"`
+	Recipient      string    `json:"recipient"`
+	IDProduct      string    `json:"id_product"`
+	Name           string    `json:"name"`
+	Unit           string    `json:"unit"`
+	Quantity       float64   `json:"quantity"`
+	Expiration     string    `json:"expiration"`
+	Description    string    `json:"description"`
+	Reason         string    `json:"reason"`
+}
+
+// Gets the lines of the cars exported between the dates (YYYY-MM-DD), the dates, type and warehouse are optional
+func GetExportedCarLines(db *pgxpool.Pool, from string, to string, car_type string, id_warehouse string) ([]ExportedCarLine, error) {
+
+	query := `
+		SELECT
+			c.id_car,
+			COALESCE(c.document_number, ''),
+			c.type,
+			c.subtype,
+			c.id_warehouse,
+			c.exported_at,
+			COALESCE(TO_CHAR(c.document_date, 'YYYY-MM-DD'), ''),
+			COALESCE(c.donor_name, ''),
+			c.recipient,
+			pc.id_product,
+			COALESCE(pc.name, p.name),
+			COALESCE(pc.unit, p.unit),
+			pc.quantity,
+			pc.expiration,
+			pc.description,
+			pc.reason
+		FROM cars c
+		JOIN products_car pc ON pc.id_car = c.id_car
+		JOIN products p ON p.id_product = pc.id_product
+		WHERE c.exported_at IS NOT NULL
+			AND ($1 = '' OR c.exported_at >= NULLIF($1, '')::date)
+			AND ($2 = '' OR c.exported_at < NULLIF($2, '')::date + INTERVAL '1 day')
+			AND ($3 = '' OR c.type = $3)
+			AND ($4 = '' OR c.id_warehouse = $4)
+		ORDER BY c.exported_at, c.id_car, pc.id_product, pc.expiration, pc.id
+	`
+
+	rows, err := db.Query(context.Background(), query, from, to, car_type, id_warehouse)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	lines := []ExportedCarLine{}
+	for rows.Next() {
+		var line ExportedCarLine
+		err := rows.Scan(
+			&line.IDCar,
+			&line.DocumentNumber,
+			&line.Type,
+			&line.Subtype,
+			&line.Warehouse,
+			&line.ExportedAt,
+			&line.DocumentDate,
+			&line.DonorName,
+			&line.Recipient,
+			&line.IDProduct,
+			&line.Name,
+			&line.Unit,
+			&line.Quantity,
+			&line.Expiration,
+			&line.Description,
+			&line.Reason,
+		)
+		if err != nil {
+			return nil, err
+		}
+		lines = append(lines, line)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return lines, nil
+}
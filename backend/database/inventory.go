@@ -0,0 +1,193 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Type of the movements that correct the ledger after an inventory
+const MovementTypeAjuste = "Ajuste"
+
+// Errors returned when approving an inventory
+var (
+	ErrInventoryNotFound        = errors.New("inventory does not exist or was not exported yet")
+	ErrInventoryAlreadyApproved = errors.New("inventory was already approved")
+)
+
+// Struct of the difference between what was counted and what the ledger expected for a lot
+type InventoryVariance struct {
+	IDProduct  string  `json:"id_product"`
+	Name       string  `json:"name"`
+	Unit       string  `json:"unit"`
+	Expiration string  `json:"expiration"`
+	Expected   float64 `json:"expected"`
+	Counted    float64 `json:"counted"`
+	Difference float64 `json:"difference"`
+}
+
+// Struct of the variance report of an inventory car
+type InventoryReport struct {
+	IDCar      string              `json:"id_car"`
+	Warehouse  string              `json:"id_warehouse"`
+	Approved   bool                `json:"approved"`
+	ApprovedAt *time.Time          `json:"approved_at"`
+	Variances  []InventoryVariance `json:"variances"`
+}
+
+// Compares the counted quantities with the ledger and keeps the result
+// Every lot of a counted product is compared, a lot that was not found on the shelf was counted as zero
+// Only the ledger of the warehouse of the car is compared
+func saveInventoryVariances(ctx context.Context, tx pgx.Tx, car *Car) error {
+
+	query := `
+		WITH counted AS (
+			SELECT id_product, expiration, SUM(quantity) AS quantity
+			FROM products_car
+			WHERE id_car = $1
+			GROUP BY id_product, expiration
+		), expected AS (
+			SELECT id_product, expiration, SUM(quantity) AS quantity
+			FROM stock_movements
+			WHERE id_warehouse = $2 AND id_product IN (SELECT id_product FROM counted)
+			GROUP BY id_product, expiration
+		)
+		INSERT INTO inventory_variances (id_car, id_warehouse, id_product, expiration, expected, counted, difference)
+		SELECT
+			$1,
+			$2,
+			COALESCE(c.id_product, e.id_product),
+			COALESCE(c.expiration, e.expiration),
+			COALESCE(e.quantity, 0),
+			COALESCE(c.quantity, 0),
+			COALESCE(c.quantity, 0) - COALESCE(e.quantity, 0)
+		FROM counted c
+		FULL OUTER JOIN expected e ON c.id_product = e.id_product AND c.expiration = e.expiration
+	`
+	_, err := tx.Exec(ctx, query, car.ID, car.IDWarehouse)
+
+	return err
+}
+
+// Gets the variance report of an inventory, returns ErrInventoryNotFound if it was not exported
+func GetInventoryReport(db *pgxpool.Pool, id_car string) (*InventoryReport, error) {
+
+	// The car tells if it was exported, an inventory where nothing was counted has no variances
+	report := InventoryReport{IDCar: id_car, Variances: []InventoryVariance{}}
+	query := `
+		SELECT id_warehouse
+		FROM cars
+		WHERE id_car = $1 AND type = $2 AND exported_at IS NOT NULL
+	`
+	err := db.QueryRow(context.Background(), query, id_car, CarTypeInventario).Scan(&report.Warehouse)
+	if err == pgx.ErrNoRows {
+		return nil, ErrInventoryNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	query = `
+		SELECT v.id_product, p.name, p.unit, v.expiration, v.expected, v.counted, v.difference
+		FROM inventory_variances v
+		JOIN products p ON p.id_product = v.id_product
+		WHERE v.id_car = $1
+		ORDER BY v.id_product, v.expiration
+	`
+
+	rows, err := db.Query(context.Background(), query, id_car)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var variance InventoryVariance
+		err := rows.Scan(
+			&variance.IDProduct,
+			&variance.Name,
+			&variance.Unit,
+			&variance.Expiration,
+			&variance.Expected,
+			&variance.Counted,
+			&variance.Difference,
+		)
+		if err != nil {
+			return nil, err
+		}
+		report.Variances = append(report.Variances, variance)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	query = `
+		SELECT approved_at
+		FROM inventory_approvals
+		WHERE id_car = $1
+	`
+	var approvedAt time.Time
+	err = db.QueryRow(context.Background(), query, id_car).Scan(&approvedAt)
+	if err == nil {
+		report.Approved = true
+		report.ApprovedAt = &approvedAt
+	} else if err != pgx.ErrNoRows {
+		return nil, err
+	}
+
+	return &report, nil
+}
+
+// Approves the inventory and posts the adjustment movements that make the ledger match the count
+func ApproveInventory(db *pgxpool.Pool, id_car string) error {
+
+	ctx := context.Background()
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	var exists bool
+	query := `
+		SELECT EXISTS (
+			SELECT 1 FROM cars
+			WHERE id_car = $1 AND type = $2 AND exported_at IS NOT NULL
+		)
+	`
+	if err = tx.QueryRow(ctx, query, id_car, CarTypeInventario).Scan(&exists); err != nil {
+		return err
+	}
+	if !exists {
+		return ErrInventoryNotFound
+	}
+
+	// The approval is registered first so the same inventory is never adjusted twice
+	query = `
+		INSERT INTO inventory_approvals (id_car)
+		VALUES ($1)
+		ON CONFLICT (id_car) DO NOTHING
+	`
+	tag, err := tx.Exec(ctx, query, id_car)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrInventoryAlreadyApproved
+	}
+
+	query = `
+		INSERT INTO stock_movements (id_car, id_product, quantity, expiration, type, id_warehouse)
+		SELECT id_car, id_product, difference, expiration, $2, id_warehouse
+		FROM inventory_variances
+		WHERE id_car = $1 AND ROUND(difference::numeric, 3) <> 0
+	`
+	if _, err = tx.Exec(ctx, query, id_car, MovementTypeAjuste); err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
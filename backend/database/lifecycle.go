@@ -0,0 +1,178 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"log"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// States of the life of a car
+const (
+	CarStatusOpen      = "open"
+	CarStatusLocked    = "locked"
+	CarStatusExported  = "exported"
+	CarStatusCancelled = "cancelled"
+	CarStatusArchived  = "archived"
+)
+
+// States a car can go to from each state
+var carTransitions = map[string][]string{
+	CarStatusOpen:      {CarStatusLocked, CarStatusExported, CarStatusCancelled},
+	CarStatusLocked:    {CarStatusOpen, CarStatusExported, CarStatusCancelled},
+	CarStatusExported:  {CarStatusArchived},
+	CarStatusCancelled: {CarStatusArchived},
+}
+
+// Column with the moment the car entered each state
+var carStatusColumns = map[string]string{
+	CarStatusOpen:      "opened_at",
+	CarStatusLocked:    "locked_at",
+	CarStatusExported:  "exported_at",
+	CarStatusCancelled: "cancelled_at",
+	CarStatusArchived:  "archived_at",
+}
+
+// Errors about the state of a car
+var (
+	ErrCarNotFound         = errors.New("car does not exist")
+	ErrCarNotOpen          = errors.New("car is not open, it can not be changed")
+	ErrInvalidTransition   = errors.New("the car can not go to that state")
+	ErrExportNeedsExporter = errors.New("a car can only be exported through the export")
+	ErrCarConflict         = errors.New("the car was changed by someone else, it is not in the version that was edited")
+	ErrLineConflict        = errors.New("the line was changed or removed by someone else, it is not in the version that was edited")
+)
+
+// Checks if a car in the state from can go to the state to
+func CanTransition(from string, to string) bool {
+	for _, next := range carTransitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// Changes the state of the car inside the transaction and returns the car as it is after the change
+// The car stays locked until the end of the transaction
+func transitionCar(ctx context.Context, tx pgx.Tx, id_car string, to string) (*Car, error) {
+
+	query := `
+		SELECT status
+		FROM cars
+		WHERE id_car = $1
+		FOR UPDATE
+	`
+	var from string
+	err := tx.QueryRow(ctx, query, id_car).Scan(&from)
+	if err == pgx.ErrNoRows {
+		return nil, ErrCarNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if !CanTransition(from, to) {
+		return nil, ErrInvalidTransition
+	}
+
+	// The column comes from carStatusColumns, never from the user
+	query = `
+		UPDATE cars
+		SET status = $2, ` + carStatusColumns[to] + ` = CURRENT_TIMESTAMP,
+			last_activity_at = CURRENT_TIMESTAMP, stale_at = NULL, version = version + 1
+		WHERE id_car = $1
+		RETURNING ` + carColumns + `
+	`
+	var car Car
+	if err = scanCar(tx.QueryRow(ctx, query, id_car, to), &car); err != nil {
+		return nil, err
+	}
+
+	return &car, nil
+}
+
+// Changes the state of a car, exporting has its own function because it also moves the stock
+func SetCarStatus(db *pgxpool.Pool, id_car string, to string) error {
+
+	if to == CarStatusExported {
+		return ErrExportNeedsExporter
+	}
+	if _, exists := carStatusColumns[to]; !exists {
+		return ErrInvalidTransition
+	}
+
+	ctx := context.Background()
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err = transitionCar(ctx, tx, id_car, to); err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// Locks the car for the transaction and checks it can still be changed
+// The lock is shared so edits do not block each other but wait for a change of state
+func lockOpenCar(ctx context.Context, tx pgx.Tx, id_car string) error {
+
+	query := `
+		SELECT status
+		FROM cars
+		WHERE id_car = $1
+		FOR SHARE
+	`
+	var status string
+	err := tx.QueryRow(ctx, query, id_car).Scan(&status)
+	if err == pgx.ErrNoRows {
+		return ErrCarNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	if status != CarStatusOpen {
+		return ErrCarNotOpen
+	}
+	return nil
+}
+
+// Runs the change inside a transaction only if the car is open, and saves the activity of the car
+func withOpenCar(db *pgxpool.Pool, id_car string, change func(ctx context.Context, tx pgx.Tx) error) error {
+
+	if err := runOnOpenCar(db, id_car, change); err != nil {
+		return err
+	}
+
+	// Outside the transaction, an update here would wait for the other edits holding the shared lock
+	if err := TouchCar(db, id_car); err != nil {
+		log.Println("Error saving the activity of the car:", err)
+	}
+	return nil
+}
+
+// Runs the change inside a transaction only if the car is open, without counting it as activity
+func runOnOpenCar(db *pgxpool.Pool, id_car string, change func(ctx context.Context, tx pgx.Tx) error) error {
+
+	ctx := context.Background()
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if err = lockOpenCar(ctx, tx, id_car); err != nil {
+		return err
+	}
+	if err = change(ctx, tx); err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
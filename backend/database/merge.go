@@ -0,0 +1,198 @@
+package database
+
+import (
+	"context"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Separator of the descriptions of lines that were merged
+const descriptionSeparator = "; "
+
+// Key of the lines that are the same, lines with different reasons are kept apart for the write-off reports
+type lineKey struct {
+	IDProduct  string
+	Expiration string
+	Reason     string
+}
+
+// Function that takes the lock of the lines of the car until the end of the transaction
+// The edits of the car only hold a shared lock on the car, so without this two scans of the
+// same product at the same time would both see no line and insert two
+func lockCarLines(ctx context.Context, tx pgx.Tx, id_car string) error {
+	_, err := tx.Exec(ctx, `SELECT pg_advisory_xact_lock(hashtext('products_car:' || $1))`, id_car)
+	return err
+}
+
+// Joins the description of a line with the one of the line merged into it
+// Empty and repeated descriptions are not added again
+func mergeDescriptions(current string, added string) string {
+	added = strings.TrimSpace(added)
+	if added == "" {
+		return current
+	}
+	if strings.TrimSpace(current) == "" {
+		return added
+	}
+	for _, part := range strings.Split(current, descriptionSeparator) {
+		if strings.EqualFold(strings.TrimSpace(part), added) {
+			return current
+		}
+	}
+	return current + descriptionSeparator + added
+}
+
+// Adds the quantity to the line with the same product, expiration and reason, or creates the line
+// Returns the id of the line
+func addOrMergeLine(ctx context.Context, tx pgx.Tx, id_car string, line Car_Product) (int, error) {
+
+	if err := lockCarLines(ctx, tx, id_car); err != nil {
+		return 0, err
+	}
+
+	query := `
+		SELECT id, description
+		FROM products_car
+		WHERE id_car = $1 AND id_product = $2 AND expiration = $3 AND reason = $4
+		ORDER BY id
+		LIMIT 1
+	`
+	var id int
+	var description string
+	err := tx.QueryRow(ctx, query, id_car, line.IDProduct, line.Expiration, line.Reason).Scan(&id, &description)
+	if err == pgx.ErrNoRows {
+		query = `
+			INSERT INTO products_car (id_car, id_product, quantity, expiration, description, reason)
+			VALUES ($1, $2, $3, $4, $5, $6)
+			RETURNING id
+		`
+		err = tx.QueryRow(ctx, query, id_car, line.IDProduct, line.Quantity, line.Expiration, line.Description, line.Reason).Scan(&id)
+		return id, err
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	query = `
+		UPDATE products_car
+		SET quantity = quantity + $2, description = $3, version = version + 1
+		WHERE id = $1
+	`
+	_, err = tx.Exec(ctx, query, id, line.Quantity, mergeDescriptions(description, line.Description))
+	return id, err
+}
+
+// Merges the lines of the car with the same product, expiration and reason into the oldest of them,
+// adding the quantities and joining the descriptions. With id_product only the lines of that product
+// are merged. Returns how many lines were removed
+func mergeCarLines(ctx context.Context, tx pgx.Tx, id_car string, id_product string) (int, error) {
+
+	if err := lockCarLines(ctx, tx, id_car); err != nil {
+		return 0, err
+	}
+
+	query := `
+		SELECT id, id_product, quantity, expiration, description, reason
+		FROM products_car
+		WHERE id_car = $1 AND ($2 = '' OR id_product = $2)
+		ORDER BY id
+	`
+	rows, err := tx.Query(ctx, query, id_car, id_product)
+	if err != nil {
+		return 0, err
+	}
+
+	// The first line of each key keeps the others
+	kept := map[lineKey]*Car_Product{}
+	order := []lineKey{}
+	removed := []int{}
+	for rows.Next() {
+		var line Car_Product
+		if err := rows.Scan(&line.ID, &line.IDProduct, &line.Quantity, &line.Expiration, &line.Description, &line.Reason); err != nil {
+			rows.Close()
+			return 0, err
+		}
+
+		key := lineKey{line.IDProduct, line.Expiration, line.Reason}
+		if first, ok := kept[key]; ok {
+			first.Quantity += line.Quantity
+			first.Description = mergeDescriptions(first.Description, line.Description)
+			removed = append(removed, line.ID)
+			continue
+		}
+		kept[key] = &line
+		order = append(order, key)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+
+	if len(removed) == 0 {
+		return 0, nil
+	}
+
+	if _, err = tx.Exec(ctx, `DELETE FROM products_car WHERE id = ANY($1)`, removed); err != nil {
+		return 0, err
+	}
+
+	query = `
+		UPDATE products_car
+		SET quantity = $2, description = $3, version = version + 1
+		WHERE id = $1
+	`
+	for _, key := range order {
+		line := kept[key]
+		if _, err = tx.Exec(ctx, query, line.ID, line.Quantity, line.Description); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(removed), nil
+}
+
+// Merges the repeated lines of an open car, returns how many lines were removed
+func NormalizeCar(db *pgxpool.Pool, id_car string) (int, error) {
+	return normalizeCar(db, id_car, withOpenCar)
+}
+
+// Merges the repeated lines of all the open cars, returns how many lines were removed in each car
+// that had repeated lines. The cars that stop being open in the meantime are skipped, and the cars
+// do not count it as activity, it would keep the stale cars from being cancelled
+func NormalizeOpenCars(db *pgxpool.Pool) (map[string]int, error) {
+
+	ids, err := queryCarIDs(db, `SELECT id_car FROM cars WHERE status = 'open' ORDER BY id_car`)
+	if err != nil {
+		return nil, err
+	}
+
+	merged := map[string]int{}
+	for _, id_car := range ids {
+		removed, err := normalizeCar(db, id_car, runOnOpenCar)
+		if err == ErrCarNotOpen || err == ErrCarNotFound {
+			continue
+		}
+		if err != nil {
+			return merged, err
+		}
+		if removed > 0 {
+			merged[id_car] = removed
+		}
+	}
+
+	return merged, nil
+}
+
+// Merges the repeated lines of the car inside the transaction given by run
+func normalizeCar(db *pgxpool.Pool, id_car string, run func(*pgxpool.Pool, string, func(context.Context, pgx.Tx) error) error) (int, error) {
+
+	var removed int
+	err := run(db, id_car, func(ctx context.Context, tx pgx.Tx) error {
+		var err error
+		removed, err = mergeCarLines(ctx, tx, id_car, "")
+		return err
+	})
+	return removed, err
+}
@@ -0,0 +1,29 @@
+package database
+
+import "testing"
+
+func TestMergeDescriptions(t *testing.T) {
+	tests := []struct {
+		current string
+		added   string
+		want    string
+	}{
+		{"", "", ""},
+		{"", "caixa aberta", "caixa aberta"},
+		{"   ", " caixa aberta ", "caixa aberta"},
+		{"caixa aberta", "", "caixa aberta"},
+		{"caixa aberta", "   ", "caixa aberta"},
+		{"caixa aberta", "amolgado", "caixa aberta; amolgado"},
+		{"caixa aberta", " amolgado ", "caixa aberta; amolgado"},
+		{"caixa aberta", "caixa aberta", "caixa aberta"},
+		{"caixa aberta", "Caixa Aberta", "caixa aberta"},
+		{"caixa aberta; amolgado", "amolgado", "caixa aberta; amolgado"},
+		{"caixa aberta; amolgado", "caixa", "caixa aberta; amolgado; caixa"},
+	}
+
+	for _, test := range tests {
+		if got := mergeDescriptions(test.current, test.added); got != test.want {
+			t.Errorf("mergeDescriptions(%q, %q) = %q, want %q", test.current, test.added, got, test.want)
+		}
+	}
+}
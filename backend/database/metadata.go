@@ -0,0 +1,67 @@
+package database
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Struct of the information of the export form that is kept with the car
+type CarMetadata struct {
+	IDDonor      string `json:"id_donor"`
+	DonorName    string `json:"donor_name"`
+	CountedBy    string `json:"counted_by"`
+	Recipient    string `json:"recipient"`
+	PerformedBy  string `json:"performed_by"`
+	Reason       string `json:"reason"`
+	DocumentDate string `json:"document_date"`
+}
+
+// Columns of the metadata read into the CarMetadata struct
+// The donor name is kept when the car is exported, before that it comes from the donors table
+const carMetadataColumns = `COALESCE(id_donor, ''),
+	COALESCE(cars.donor_name, (SELECT d.name FROM donors d WHERE d.id_donor = cars.id_donor), ''),
+	counted_by, recipient, performed_by, reason,
+	COALESCE(TO_CHAR(document_date, 'YYYY-MM-DD'), '')`
+
+// Errors about the metadata of a car
+var (
+	ErrDonorNotFound       = errors.New("donor does not exist")
+	ErrInvalidDocumentDate = errors.New("the date of the document must be YYYY-MM-DD")
+)
+
+// Postgres code of a foreign key that points to nothing
+const foreignKeyViolation = "23503"
+
+// Changes the information of the export form of an open car, the donor name is ignored
+// Only if the car is still in the version the user edited
+func UpdateCarMetadata(db *pgxpool.Pool, id_car string, version int, metadata CarMetadata) error {
+	return PatchCar(db, id_car, version, CarPatch{Metadata: &metadata})
+}
+
+// Keeps in the car the names it has when it is exported, so its reports are the same even if a product, donor or warehouse is renamed later
+func snapshotCarNames(ctx context.Context, tx pgx.Tx, id_car string) error {
+
+	query := `
+		UPDATE products_car pc
+		SET name = p.name, unit = p.unit
+		FROM products p
+		WHERE pc.id_product = p.id_product AND pc.id_car = $1
+	`
+	if _, err := tx.Exec(ctx, query, id_car); err != nil {
+		return err
+	}
+
+	query = `
+		UPDATE cars
+		SET donor_name = (SELECT d.name FROM donors d WHERE d.id_donor = cars.id_donor),
+			warehouse_name = (SELECT w.name FROM warehouses w WHERE w.id_warehouse = cars.id_warehouse),
+			warehouse_dest_name = (SELECT w.name FROM warehouses w WHERE w.id_warehouse = cars.id_warehouse_dest)
+		WHERE id_car = $1
+	`
+	_, err := tx.Exec(ctx, query, id_car)
+
+	return err
+}
@@ -0,0 +1,101 @@
+package database
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Struct of a product that is below its minimum quantity
+type ProductNeed struct {
+	IDProduct      string  `json:"id_product"`
+	Name           string  `json:"name"`
+	Unit           string  `json:"unit"`
+	MinQuantity    float64 `json:"min_quantity"`
+	TargetQuantity float64 `json:"target_quantity"`
+	Quantity       float64 `json:"quantity"`
+	Missing        float64 `json:"missing"`
+}
+
+// Reads the needs from rows with the product, thresholds and quantity on hand
+func scanNeeds(rows pgx.Rows) ([]ProductNeed, error) {
+
+	needs := []ProductNeed{}
+	for rows.Next() {
+		var need ProductNeed
+		err := rows.Scan(
+			&need.IDProduct,
+			&need.Name,
+			&need.Unit,
+			&need.MinQuantity,
+			&need.TargetQuantity,
+			&need.Quantity,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		// What is missing to reach the target, or the minimum if there is no target
+		need.Missing = max(need.TargetQuantity, need.MinQuantity) - need.Quantity
+		needs = append(needs, need)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return needs, nil
+}
+
+// Gets the products whose stock, summed over all the warehouses, is below the minimum
+func GetNeeds(db *pgxpool.Pool) ([]ProductNeed, error) {
+
+	query := `
+		SELECT p.id_product, p.name, p.unit, p.min_quantity, p.target_quantity, COALESCE(SUM(sm.quantity), 0)
+		FROM products p
+		LEFT JOIN stock_movements sm ON sm.id_product = p.id_product
+		WHERE p.min_quantity > 0
+		GROUP BY p.id_product, p.name, p.unit, p.min_quantity, p.target_quantity
+		HAVING COALESCE(SUM(sm.quantity), 0) < p.min_quantity
+		ORDER BY p.name
+	`
+
+	rows, err := db.Query(context.Background(), query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanNeeds(rows)
+}
+
+// Gets the products of an exported car that were at or above the minimum and went below it with the export
+func GetExportNeeds(db *pgxpool.Pool, id_car string) ([]ProductNeed, error) {
+
+	query := `
+		WITH car AS (
+			SELECT id_product, SUM(quantity) AS quantity
+			FROM products_car
+			WHERE id_car = $1
+			GROUP BY id_product
+		)
+		SELECT p.id_product, p.name, p.unit, p.min_quantity, p.target_quantity, COALESCE(SUM(sm.quantity), 0)
+		FROM car
+		JOIN products p ON p.id_product = car.id_product
+		LEFT JOIN stock_movements sm ON sm.id_product = p.id_product
+		WHERE p.min_quantity > 0
+		GROUP BY p.id_product, p.name, p.unit, p.min_quantity, p.target_quantity, car.quantity
+		HAVING COALESCE(SUM(sm.quantity), 0) < p.min_quantity
+			AND COALESCE(SUM(sm.quantity), 0) + car.quantity >= p.min_quantity
+		ORDER BY p.name
+	`
+
+	rows, err := db.Query(context.Background(), query, id_car)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanNeeds(rows)
+}
@@ -0,0 +1,164 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Struct of the changes of a car made together, the fields that are nil are not changed
+type CarPatch struct {
+	Metadata *CarMetadata
+	Subtype  *string
+	Status   *string
+}
+
+// Changes the export form, the subtype and the state of a car in one transaction, only if the car is
+// still in the version the user edited. The form and the subtype are saved before the state, so a car
+// can be filled and locked at once, and the version goes up by one for the whole patch
+// A version of 0 is only accepted when the patch only changes the state
+func PatchCar(db *pgxpool.Pool, id_car string, version int, patch CarPatch) error {
+
+	if patch.Metadata == nil && patch.Subtype == nil && patch.Status == nil {
+		return nil
+	}
+	if err := checkCarPatch(version, patch); err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	query := `
+		SELECT type, status, version
+		FROM cars
+		WHERE id_car = $1
+		FOR UPDATE
+	`
+	var car_type, status string
+	var current int
+	err = tx.QueryRow(ctx, query, id_car).Scan(&car_type, &status, &current)
+	if err == pgx.ErrNoRows {
+		return ErrCarNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	if version != 0 && version != current {
+		return ErrCarConflict
+	}
+
+	if patch.Metadata != nil || patch.Subtype != nil {
+		if status != CarStatusOpen {
+			return ErrCarNotOpen
+		}
+	}
+
+	if patch.Metadata != nil {
+		if err = saveCarMetadata(ctx, tx, id_car, *patch.Metadata); err != nil {
+			return err
+		}
+	}
+
+	if patch.Subtype != nil {
+		if car_type != CarTypeSaida {
+			return ErrCarNotSaida
+		}
+		if _, err = tx.Exec(ctx, `UPDATE cars SET subtype = $1 WHERE id_car = $2`, *patch.Subtype, id_car); err != nil {
+			return err
+		}
+	}
+
+	// The change of state also moves the version, otherwise the version moves here
+	if patch.Status != nil {
+		if _, err = transitionCar(ctx, tx, id_car, *patch.Status); err != nil {
+			return err
+		}
+	} else {
+		if _, err = tx.Exec(ctx, `UPDATE cars SET version = version + 1 WHERE id_car = $1`, id_car); err != nil {
+			return err
+		}
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return err
+	}
+
+	// Outside the transaction, like the other changes of an open car
+	if err := TouchCar(db, id_car); err != nil {
+		log.Println("Error saving the activity of the car:", err)
+	}
+	return nil
+}
+
+// Checks the values of the patch before the car is locked
+func checkCarPatch(version int, patch CarPatch) error {
+
+	// The form and the subtype overwrite what the user saw, so they need the version
+	if version == 0 && (patch.Metadata != nil || patch.Subtype != nil) {
+		return ErrCarConflict
+	}
+
+	if patch.Metadata != nil && patch.Metadata.DocumentDate != "" {
+		if _, err := time.Parse(ExpirationLayout, patch.Metadata.DocumentDate); err != nil {
+			return ErrInvalidDocumentDate
+		}
+	}
+
+	if patch.Subtype != nil && !IsValidSubtype(*patch.Subtype) {
+		return ErrInvalidSubtype
+	}
+
+	if patch.Status != nil {
+		if *patch.Status == CarStatusExported {
+			return ErrExportNeedsExporter
+		}
+		if _, exists := carStatusColumns[*patch.Status]; !exists {
+			return ErrInvalidTransition
+		}
+	}
+
+	return nil
+}
+
+// Saves the export form of the car, the donor name is ignored
+func saveCarMetadata(ctx context.Context, tx pgx.Tx, id_car string, metadata CarMetadata) error {
+
+	// Empty values are kept as NULL so the foreign key and the date accept them
+	query := `
+		UPDATE cars
+		SET id_donor = NULLIF($2, ''),
+			counted_by = $3,
+			recipient = $4,
+			performed_by = $5,
+			reason = $6,
+			document_date = NULLIF($7, '')::date
+		WHERE id_car = $1
+	`
+	_, err := tx.Exec(ctx, query,
+		id_car,
+		metadata.IDDonor,
+		metadata.CountedBy,
+		metadata.Recipient,
+		metadata.PerformedBy,
+		metadata.Reason,
+		metadata.DocumentDate,
+	)
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == foreignKeyViolation {
+		return ErrDonorNotFound
+	}
+
+	return err
+}
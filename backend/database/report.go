@@ -0,0 +1,131 @@
+package database
+
+import (
+	"sort"
+	"time"
+
+	"github.com/Samuel-k276/backend/models"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Format in which the expiration dates are stored
+const ExpirationLayout = "2006-01-02"
+
+// Struct of a lot in stock that already expired or is about to
+type ExpiryLot struct {
+	IDProduct  string  `json:"id_product"`
+	Name       string  `json:"name"`
+	Unit       string  `json:"unit"`
+	Expiration string  `json:"expiration"`
+	Quantity   float64 `json:"quantity"`
+	DaysLeft   int     `json:"days_left"`
+}
+
+// Struct of the lots of one category that are in the same place of the map
+type ExpiryGroup struct {
+	Category string      `json:"category"`
+	Pos_x    int         `json:"pos_x"`
+	Pos_y    int         `json:"pos_y"`
+	Expired  []ExpiryLot `json:"expired"`
+	Expiring []ExpiryLot `json:"expiring"`
+}
+
+// Struct of the report of the expired lots and the ones expiring in the next days
+type ExpiryReport struct {
+	GeneratedAt time.Time     `json:"generated_at"`
+	Warehouse   string        `json:"id_warehouse"`
+	Days        int           `json:"days"`
+	Expired     int           `json:"expired"`
+	Expiring    int           `json:"expiring"`
+	Groups      []ExpiryGroup `json:"groups"`
+}
+
+// Builds the report of the lots in stock that expired or expire within the days after today
+// Uses the stock of all the warehouses if id_warehouse is empty
+func GetExpiryReport(db *pgxpool.Pool, id_warehouse string, days int, now time.Time) (*ExpiryReport, error) {
+
+	stock, err := GetStock(db, id_warehouse)
+	if err != nil {
+		return nil, err
+	}
+
+	// Only the date matters, the expiration dates have no time
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+
+	report := ExpiryReport{GeneratedAt: now, Warehouse: id_warehouse, Days: days, Groups: []ExpiryGroup{}}
+
+	// The groups are found by category and position
+	type groupKey struct {
+		category     string
+		pos_x, pos_y int
+	}
+	groups := map[groupKey]*ExpiryGroup{}
+
+	for _, product := range stock {
+		for _, lot := range product.Lots {
+			// Lots without date or with a date that can not be read are left out
+			if lot.Quantity <= 0 || lot.Expiration == "" {
+				continue
+			}
+			expiration, err := time.Parse(ExpirationLayout, lot.Expiration)
+			if err != nil {
+				continue
+			}
+
+			daysLeft := int(expiration.Sub(today).Hours() / 24)
+			if daysLeft > days {
+				continue
+			}
+
+			key := groupKey{models.ProductCategory(product.IDProduct), product.Pos_x, product.Pos_y}
+			group, exists := groups[key]
+			if !exists {
+				group = &ExpiryGroup{Category: key.category, Pos_x: key.pos_x, Pos_y: key.pos_y, Expired: []ExpiryLot{}, Expiring: []ExpiryLot{}}
+				groups[key] = group
+			}
+
+			expiryLot := ExpiryLot{
+				IDProduct:  product.IDProduct,
+				Name:       product.Name,
+				Unit:       product.Unit,
+				Expiration: lot.Expiration,
+				Quantity:   lot.Quantity,
+				DaysLeft:   daysLeft,
+			}
+			if daysLeft < 0 {
+				group.Expired = append(group.Expired, expiryLot)
+				report.Expired++
+			} else {
+				group.Expiring = append(group.Expiring, expiryLot)
+				report.Expiring++
+			}
+		}
+	}
+
+	for _, group := range groups {
+		sortExpiryLots(group.Expired)
+		sortExpiryLots(group.Expiring)
+		report.Groups = append(report.Groups, *group)
+	}
+
+	// Ordered by category and then by position so the report can be followed walking the warehouse
+	sort.Slice(report.Groups, func(i, j int) bool {
+		a, b := report.Groups[i], report.Groups[j]
+		if a.Category != b.Category {
+			return a.Category < b.Category
+		}
+		if a.Pos_y != b.Pos_y {
+			return a.Pos_y < b.Pos_y
+		}
+		return a.Pos_x < b.Pos_x
+	})
+
+	return &report, nil
+}
+
+// Orders the lots by the date they expire
+func sortExpiryLots(lots []ExpiryLot) {
+	sort.SliceStable(lots, func(i, j int) bool {
+		return lots[i].Expiration < lots[j].Expiration
+	})
+}
@@ -0,0 +1,149 @@
+package database
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Function that marks the car as used now, a car that was flagged as stale is not stale anymore
+// It runs after the change is committed, so it never waits holding the lock of the change
+func TouchCar(db *pgxpool.Pool, id_car string) error {
+
+	query := `
+		UPDATE cars
+		SET last_activity_at = CURRENT_TIMESTAMP, stale_at = NULL
+		WHERE id_car = $1 AND status IN ('open', 'locked')
+	`
+	_, err := db.Exec(context.Background(), query, id_car)
+	return err
+}
+
+// Flags the open and locked cars without activity for more than days, returns the ids of the cars
+// flagged now, the ones that were already flagged are not returned again
+func FlagStaleCars(db *pgxpool.Pool, days int) ([]string, error) {
+
+	query := `
+		UPDATE cars
+		SET stale_at = CURRENT_TIMESTAMP
+		WHERE status IN ('open', 'locked')
+			AND stale_at IS NULL
+			AND last_activity_at < CURRENT_TIMESTAMP - make_interval(days => $1)
+		RETURNING id_car
+	`
+	return queryCarIDs(db, query, days)
+}
+
+// Cancels the cars that were flagged more than hours ago and had no activity since then
+// Each car goes through transitionCar, so a car exported in the meantime is left alone
+func CancelStaleCars(db *pgxpool.Pool, hours int) ([]string, error) {
+
+	query := `
+		SELECT id_car
+		FROM cars
+		WHERE status IN ('open', 'locked')
+			AND stale_at < CURRENT_TIMESTAMP - make_interval(hours => $1)
+	`
+	ids, err := queryCarIDs(db, query, hours)
+	if err != nil {
+		return nil, err
+	}
+
+	cancelled := []string{}
+	for _, id_car := range ids {
+		ok, err := cancelStaleCar(db, id_car, hours)
+		if err != nil {
+			return cancelled, err
+		}
+		if ok {
+			cancelled = append(cancelled, id_car)
+		}
+	}
+
+	return cancelled, nil
+}
+
+// Cancels one stale car, checking again with the car locked that nobody used it in the meantime
+func cancelStaleCar(db *pgxpool.Pool, id_car string, hours int) (bool, error) {
+
+	ctx := context.Background()
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback(ctx)
+
+	query := `
+		SELECT stale_at IS NOT NULL AND stale_at < CURRENT_TIMESTAMP - make_interval(hours => $2)
+		FROM cars
+		WHERE id_car = $1
+		FOR UPDATE
+	`
+	var stale bool
+	if err = tx.QueryRow(ctx, query, id_car, hours).Scan(&stale); err != nil {
+		return false, err
+	}
+	if !stale {
+		return false, nil
+	}
+
+	if _, err = transitionCar(ctx, tx, id_car, CarStatusCancelled); err != nil {
+		return false, err
+	}
+
+	return true, tx.Commit(ctx)
+}
+
+// Gets the open and locked cars without activity for more than days, flagged or not yet,
+// the ones that have been stopped for longer first
+func GetStaleCars(db *pgxpool.Pool, days int) ([]Car, error) {
+
+	query := `
+		SELECT ` + carColumns + `
+		FROM cars
+		WHERE status IN ('open', 'locked')
+			AND (stale_at IS NOT NULL OR last_activity_at < CURRENT_TIMESTAMP - make_interval(days => $1))
+		ORDER BY last_activity_at, id_car
+	`
+	rows, err := db.Query(context.Background(), query, days)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	cars := []Car{}
+	for rows.Next() {
+		var car Car
+		if err := scanCar(rows, &car); err != nil {
+			return nil, err
+		}
+		cars = append(cars, car)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return cars, nil
+}
+
+// Runs a query that returns ids of cars
+func queryCarIDs(db *pgxpool.Pool, query string, args ...interface{}) ([]string, error) {
+
+	rows, err := db.Query(context.Background(), query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := []string{}
+	for rows.Next() {
+		var id_car string
+		if err := rows.Scan(&id_car); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id_car)
+	}
+
+	return ids, rows.Err()
+}
@@ -0,0 +1,403 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/Samuel-k276/backend/constants"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Struct of a movement in the stock ledger, positive quantities enter the warehouse and negative ones leave it
+type StockMovement struct {
+	ID         int       `json:"id"`
+	IDCar      string    `json:"id_car"`
+	IDProduct  string    `json:"id_product"`
+	Quantity   float64   `json:"quantity"`
+	Expiration string    `json:"expiration"`
+	Type       string    `json:"type"`
+	Subtype    string    `json:"subtype"`
+	Reason     string    `json:"reason"`
+	Warehouse  string    `json:"id_warehouse"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// Returns the sign of the movements created by a car of that type
+func movementSign(car_type string) (float64, error) {
+	switch car_type {
+	case CarTypeEntrada:
+		return 1, nil
+	case CarTypeSaida:
+		return -1, nil
+	}
+	return 0, fmt.Errorf("unknown car type: %s", car_type)
+}
+
+// Writes the products of the car to the ledger, one movement per product, expiration date and reason
+// A Transferência leaves the origin warehouse and enters the destination in the same transaction
+func postCarMovements(ctx context.Context, tx pgx.Tx, car *Car) error {
+
+	if car.Type == CarTypeTransferencia {
+		if err := insertCarMovements(ctx, tx, car, car.IDWarehouse, -1); err != nil {
+			return err
+		}
+		return insertCarMovements(ctx, tx, car, car.IDWarehouseDest, 1)
+	}
+
+	sign, err := movementSign(car.Type)
+	if err != nil {
+		return err
+	}
+
+	return insertCarMovements(ctx, tx, car, car.IDWarehouse, sign)
+}
+
+// Inserts the movements of the lines of the car in that warehouse with that sign
+func insertCarMovements(ctx context.Context, tx pgx.Tx, car *Car, id_warehouse string, sign float64) error {
+
+	// Lines with the same product, expiration date and reason become a single movement
+	query := `
+		INSERT INTO stock_movements (id_car, id_product, quantity, expiration, type, subtype, reason, id_warehouse)
+		SELECT id_car, id_product, SUM(quantity) * $2, expiration, $3, $4, reason, $5
+		FROM products_car
+		WHERE id_car = $1
+		GROUP BY id_car, id_product, expiration, reason
+	`
+	_, err := tx.Exec(ctx, query, car.ID, sign, car.Type, car.Subtype, id_warehouse)
+
+	return err
+}
+
+// Gets the movements of the ledger, the filters by product, warehouse and dates (YYYY-MM-DD) are only used if not empty
+func GetStockMovements(db *pgxpool.Pool, id_product string, id_warehouse string, from string, to string) ([]StockMovement, error) {
+
+	query := `
+		SELECT id, id_car, id_product, quantity, expiration, type, subtype, reason, id_warehouse, created_at
+		FROM stock_movements
+		WHERE ($1 = '' OR id_product = $1) AND ($2 = '' OR id_warehouse = $2)
+			AND ($3 = '' OR created_at >= NULLIF($3, '')::date)
+			AND ($4 = '' OR created_at < NULLIF($4, '')::date + INTERVAL '1 day')
+		ORDER BY created_at, id
+	`
+
+	rows, err := db.Query(context.Background(), query, id_product, id_warehouse, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	movements := []StockMovement{}
+	for rows.Next() {
+		var movement StockMovement
+		err := rows.Scan(
+			&movement.ID,
+			&movement.IDCar,
+			&movement.IDProduct,
+			&movement.Quantity,
+			&movement.Expiration,
+			&movement.Type,
+			&movement.Subtype,
+			&movement.Reason,
+			&movement.Warehouse,
+			&movement.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		movements = append(movements, movement)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return movements, nil
+}
+
+// Struct of the quantity on hand of one expiration date (lot) of a product
+type StockLot struct {
+	Expiration string  `json:"expiration"`
+	Quantity   float64 `json:"quantity"`
+}
+
+// Struct of the quantity on hand of a product, with the information needed to find it in the warehouse
+type ProductStock struct {
+	IDProduct string     `json:"id_product"`
+	Name      string     `json:"name"`
+	Unit      string     `json:"unit"`
+	Pos_x     int        `json:"pos_x"`
+	Pos_y     int        `json:"pos_y"`
+	Quantity  float64    `json:"quantity"`
+	Lots      []StockLot `json:"lots"`
+}
+
+// Sums the ledger by product and lot, the filters by warehouse and product are only used if not empty
+func queryStock(db *pgxpool.Pool, id_warehouse string, id_product string) ([]ProductStock, error) {
+
+	// Lots that were completely emptied are left out
+	query := `
+		SELECT p.id_product, p.name, p.unit, p.pos_x, p.pos_y, sm.expiration, SUM(sm.quantity)
+		FROM stock_movements sm
+		JOIN products p ON p.id_product = sm.id_product
+		WHERE ($1 = '' OR sm.id_product = $1) AND ($2 = '' OR sm.id_warehouse = $2)
+		GROUP BY p.id_product, p.name, p.unit, p.pos_x, p.pos_y, sm.expiration
+		HAVING ROUND(SUM(sm.quantity)::numeric, 3) <> 0
+		ORDER BY p.id_product, sm.expiration
+	`
+
+	rows, err := db.Query(context.Background(), query, id_product, id_warehouse)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	stock := []ProductStock{}
+	for rows.Next() {
+		var product ProductStock
+		var lot StockLot
+		err := rows.Scan(
+			&product.IDProduct,
+			&product.Name,
+			&product.Unit,
+			&product.Pos_x,
+			&product.Pos_y,
+			&lot.Expiration,
+			&lot.Quantity,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		// The rows come ordered by product so the lots of a product are always together
+		if len(stock) == 0 || stock[len(stock)-1].IDProduct != product.IDProduct {
+			product.Lots = []StockLot{}
+			stock = append(stock, product)
+		}
+		last := &stock[len(stock)-1]
+		last.Lots = append(last.Lots, lot)
+		last.Quantity += lot.Quantity
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return stock, nil
+}
+
+// Gets the quantity on hand of every product that has stock, in all the warehouses if id_warehouse is empty
+func GetStock(db *pgxpool.Pool, id_warehouse string) ([]ProductStock, error) {
+	return queryStock(db, id_warehouse, "")
+}
+
+// Gets the quantity on hand of one product, returns pgx.ErrNoRows if the product does not exist
+func GetProductStock(db *pgxpool.Pool, id_warehouse string, id_product string) (*ProductStock, error) {
+
+	stock, err := queryStock(db, id_warehouse, id_product)
+	if err != nil {
+		return nil, err
+	}
+	if len(stock) > 0 {
+		return &stock[0], nil
+	}
+
+	// Without movements the product still exists, it just has nothing on hand
+	query := `
+		SELECT id_product, name, unit, pos_x, pos_y
+		FROM products
+		WHERE id_product = $1
+	`
+	product := ProductStock{Lots: []StockLot{}}
+	err = db.QueryRow(context.Background(), query, id_product).Scan(
+		&product.IDProduct,
+		&product.Name,
+		&product.Unit,
+		&product.Pos_x,
+		&product.Pos_y,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &product, nil
+}
+
+// Struct of a lot the volunteer should take to fill a line of a Saída car
+type PickSuggestion struct {
+	Expiration string  `json:"expiration"`
+	Quantity   float64 `json:"quantity"`
+}
+
+// Splits the quantity over the lots, the lots that expire first are taken first (FEFO)
+// The lots that expired before today (YYYY-MM-DD) are never taken, they are returned apart to be removed
+// Returns the lots to take, the expired lots and the quantity that the lots could not cover
+func allocateFEFO(lots []StockLot, quantity float64, today string) ([]PickSuggestion, []PickSuggestion, float64) {
+
+	// The dates are stored as YYYY-MM-DD so the text order is the date order, lots without date go last
+	sorted := slices.Clone(lots)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if (sorted[i].Expiration == "") != (sorted[j].Expiration == "") {
+			return sorted[j].Expiration == ""
+		}
+		return sorted[i].Expiration < sorted[j].Expiration
+	})
+
+	suggestions := []PickSuggestion{}
+	expired := []PickSuggestion{}
+	for _, lot := range sorted {
+		if lot.Quantity <= 0 {
+			continue
+		}
+		if lot.Expiration != "" && lot.Expiration < today {
+			expired = append(expired, PickSuggestion{Expiration: lot.Expiration, Quantity: lot.Quantity})
+			continue
+		}
+		if quantity <= 0 {
+			continue
+		}
+
+		take := min(lot.Quantity, quantity)
+		suggestions = append(suggestions, PickSuggestion{Expiration: lot.Expiration, Quantity: take})
+		quantity -= take
+	}
+
+	return suggestions, expired, max(quantity, 0)
+}
+
+// Suggests which lots of the product to take for a line of the car following FEFO
+// Only the stock of the warehouse of the car is used and what is already in the car is not counted as available
+// The expired lots are not suggested, they are returned apart so the volunteer can take them off the shelf
+func SuggestFEFO(db *pgxpool.Pool, id_car string, id_product string, quantity float64) ([]PickSuggestion, []PickSuggestion, float64, error) {
+
+	var id_warehouse string
+	query := `
+		SELECT id_warehouse
+		FROM cars
+		WHERE id_car = $1
+	`
+	if err := db.QueryRow(context.Background(), query, id_car).Scan(&id_warehouse); err != nil {
+		return nil, nil, 0, err
+	}
+
+	stock, err := queryStock(db, id_warehouse, id_product)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+
+	var lots []StockLot
+	if len(stock) > 0 {
+		lots = stock[0].Lots
+	}
+
+	// Query to get what the car already takes of each lot
+	query = `
+		SELECT expiration, SUM(quantity)
+		FROM products_car
+		WHERE id_car = $1 AND id_product = $2
+		GROUP BY expiration
+	`
+	rows, err := db.Query(context.Background(), query, id_car, id_product)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	defer rows.Close()
+
+	reserved := map[string]float64{}
+	for rows.Next() {
+		var expiration string
+		var amount float64
+		if err := rows.Scan(&expiration, &amount); err != nil {
+			return nil, nil, 0, err
+		}
+		reserved[expiration] = amount
+	}
+	if err = rows.Err(); err != nil {
+		return nil, nil, 0, err
+	}
+
+	available := make([]StockLot, 0, len(lots))
+	for _, lot := range lots {
+		lot.Quantity -= reserved[lot.Expiration]
+		available = append(available, lot)
+	}
+
+	// The expiration dates have no time, the day is the one of the food bank
+	today := time.Now().In(constants.GetLocation()).Format(ExpirationLayout)
+
+	suggestions, expired, missing := allocateFEFO(available, quantity, today)
+	return suggestions, expired, missing, nil
+}
+
+// Struct of a product and lot of a car that the stock does not cover
+type StockShortage struct {
+	IDProduct  string  `json:"id_product"`
+	Expiration string  `json:"expiration"`
+	Requested  float64 `json:"requested"`
+	Available  float64 `json:"available"`
+	Lines      []int   `json:"lines"`
+}
+
+// Error returned when exporting a car that takes more than what is in stock
+type StockShortageError struct {
+	Shortages []StockShortage `json:"shortages"`
+}
+
+func (e *StockShortageError) Error() string {
+	return fmt.Sprintf("%d product(s) of the car without enough stock", len(e.Shortages))
+}
+
+// Checks that the warehouse of the car has stock for every line it takes
+// The ledger stays locked until the end of the transaction so two exports can not take the same stock
+func checkStockAvailable(ctx context.Context, tx pgx.Tx, car *Car) error {
+
+	if _, err := tx.Exec(ctx, `LOCK TABLE stock_movements IN SHARE ROW EXCLUSIVE MODE`); err != nil {
+		return err
+	}
+
+	query := `
+		WITH car AS (
+			SELECT id_product, expiration, SUM(quantity) AS quantity, array_agg(id ORDER BY id) AS lines
+			FROM products_car
+			WHERE id_car = $1
+			GROUP BY id_product, expiration
+		), stock AS (
+			SELECT id_product, expiration, SUM(quantity) AS quantity
+			FROM stock_movements
+			WHERE id_warehouse = $2 AND id_product IN (SELECT id_product FROM car)
+			GROUP BY id_product, expiration
+		)
+		SELECT car.id_product, car.expiration, car.quantity, COALESCE(stock.quantity, 0), car.lines
+		FROM car
+		LEFT JOIN stock ON stock.id_product = car.id_product AND stock.expiration = car.expiration
+		WHERE ROUND((car.quantity - COALESCE(stock.quantity, 0))::numeric, 3) > 0
+		ORDER BY car.id_product, car.expiration
+	`
+
+	rows, err := tx.Query(ctx, query, car.ID, car.IDWarehouse)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	shortage := &StockShortageError{Shortages: []StockShortage{}}
+	for rows.Next() {
+		var line StockShortage
+		err := rows.Scan(&line.IDProduct, &line.Expiration, &line.Requested, &line.Available, &line.Lines)
+		if err != nil {
+			return err
+		}
+		shortage.Shortages = append(shortage.Shortages, line)
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	if len(shortage.Shortages) > 0 {
+		return shortage
+	}
+	return nil
+}
@@ -0,0 +1,138 @@
+package database
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestAllocateFEFO(t *testing.T) {
+	const today = "2026-05-10"
+
+	tests := []struct {
+		name        string
+		lots        []StockLot
+		quantity    float64
+		wantPick    []PickSuggestion
+		wantExpired []PickSuggestion
+		wantMissing float64
+	}{
+		{
+			name:        "no lots",
+			lots:        nil,
+			quantity:    3,
+			wantPick:    []PickSuggestion{},
+			wantExpired: []PickSuggestion{},
+			wantMissing: 3,
+		},
+		{
+			name:        "one lot covers it",
+			lots:        []StockLot{{"2026-06-01", 10}},
+			quantity:    4,
+			wantPick:    []PickSuggestion{{"2026-06-01", 4}},
+			wantExpired: []PickSuggestion{},
+		},
+		{
+			name:        "first to expire first",
+			lots:        []StockLot{{"2026-09-01", 5}, {"2026-06-01", 2}, {"2026-07-01", 5}},
+			quantity:    6,
+			wantPick:    []PickSuggestion{{"2026-06-01", 2}, {"2026-07-01", 4}},
+			wantExpired: []PickSuggestion{},
+		},
+		{
+			name:        "lots without date go last",
+			lots:        []StockLot{{"", 5}, {"2027-01-01", 1}},
+			quantity:    3,
+			wantPick:    []PickSuggestion{{"2027-01-01", 1}, {"", 2}},
+			wantExpired: []PickSuggestion{},
+		},
+		{
+			name:        "expired lots are never taken",
+			lots:        []StockLot{{"2026-05-01", 4}, {"2026-05-20", 2}},
+			quantity:    3,
+			wantPick:    []PickSuggestion{{"2026-05-20", 2}},
+			wantExpired: []PickSuggestion{{"2026-05-01", 4}},
+			wantMissing: 1,
+		},
+		{
+			name:        "a lot that expires today is still taken",
+			lots:        []StockLot{{"2026-05-09", 1}, {today, 2}},
+			quantity:    2,
+			wantPick:    []PickSuggestion{{today, 2}},
+			wantExpired: []PickSuggestion{{"2026-05-09", 1}},
+		},
+		{
+			name:        "expired lots are listed even when the quantity is covered",
+			lots:        []StockLot{{"2026-06-01", 10}, {"2026-04-01", 3}},
+			quantity:    1,
+			wantPick:    []PickSuggestion{{"2026-06-01", 1}},
+			wantExpired: []PickSuggestion{{"2026-04-01", 3}},
+		},
+		{
+			name:        "empty and negative lots are skipped",
+			lots:        []StockLot{{"2026-06-01", 0}, {"2026-04-01", -2}, {"2026-07-01", 3}},
+			quantity:    1,
+			wantPick:    []PickSuggestion{{"2026-07-01", 1}},
+			wantExpired: []PickSuggestion{},
+		},
+		{
+			name:        "not enough stock",
+			lots:        []StockLot{{"2026-06-01", 1.5}},
+			quantity:    4,
+			wantPick:    []PickSuggestion{{"2026-06-01", 1.5}},
+			wantExpired: []PickSuggestion{},
+			wantMissing: 2.5,
+		},
+		{
+			name:        "nothing asked",
+			lots:        []StockLot{{"2026-06-01", 3}},
+			quantity:    0,
+			wantPick:    []PickSuggestion{},
+			wantExpired: []PickSuggestion{},
+		},
+	}
+
+	for _, test := range tests {
+		pick, expired, missing := allocateFEFO(test.lots, test.quantity, today)
+		if fmt.Sprint(pick) != fmt.Sprint(test.wantPick) {
+			t.Errorf("%s: picked %v, want %v", test.name, pick, test.wantPick)
+		}
+		if fmt.Sprint(expired) != fmt.Sprint(test.wantExpired) {
+			t.Errorf("%s: expired %v, want %v", test.name, expired, test.wantExpired)
+		}
+		if missing != test.wantMissing {
+			t.Errorf("%s: missing %v, want %v", test.name, missing, test.wantMissing)
+		}
+	}
+}
+
+func TestAllocateFEFOKeepsTheLots(t *testing.T) {
+	lots := []StockLot{{"2026-09-01", 5}, {"2026-06-01", 2}}
+	allocateFEFO(lots, 3, "2026-05-10")
+
+	if lots[0].Expiration != "2026-09-01" || lots[1].Expiration != "2026-06-01" {
+		t.Errorf("the lots of the caller were sorted: %v", lots)
+	}
+}
+
+func TestMovementSign(t *testing.T) {
+	tests := []struct {
+		carType string
+		want    float64
+		wantErr bool
+	}{
+		{CarTypeEntrada, 1, false},
+		{CarTypeSaida, -1, false},
+		// The transfers post both signs and the inventories only post after the approval
+		{CarTypeTransferencia, 0, true},
+		{CarTypeInventario, 0, true},
+		{"", 0, true},
+		{"entrada", 0, true},
+	}
+
+	for _, test := range tests {
+		got, err := movementSign(test.carType)
+		if got != test.want || (err != nil) != test.wantErr {
+			t.Errorf("movementSign(%q) = %v, %v, want %v (error %v)", test.carType, got, err, test.want, test.wantErr)
+		}
+	}
+}
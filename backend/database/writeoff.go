@@ -0,0 +1,196 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Subtypes of a Saída car, everything that is not a donation is a write-off (quebra)
+const (
+	SubtypeDonation    = "donation"
+	SubtypeBreakage    = "breakage"
+	SubtypeExpired     = "expired"
+	SubtypeRecall      = "recall"
+	SubtypeInternalUse = "internal_use"
+)
+
+// All the subtypes accepted in a Saída car
+var SaidaSubtypes = []string{SubtypeDonation, SubtypeBreakage, SubtypeExpired, SubtypeRecall, SubtypeInternalUse}
+
+// Reason codes of a write-off line and their description
+var WriteOffReasons = map[string]string{
+	"damaged_packaging": "Embalagem danificada",
+	"broken":            "Partido",
+	"expired":           "Fora de validade",
+	"spoiled":           "Estragado",
+	"recall":            "Recolha do fabricante",
+	"pests":             "Pragas",
+	"internal_use":      "Uso interno",
+	"other":             "Outro",
+}
+
+// Errors about the subtype of a car
+var (
+	ErrInvalidSubtype = errors.New("invalid subtype for a Saída car")
+	ErrCarNotSaida    = errors.New("only Saída cars have a subtype")
+	ErrInvalidReason  = errors.New("invalid reason code for a write-off line")
+)
+
+// Error returned when exporting a write-off with lines without a valid reason code
+type MissingReasonError struct {
+	Lines []int `json:"lines"`
+}
+
+func (e *MissingReasonError) Error() string {
+	return fmt.Sprintf("%d line(s) of the write-off without a valid reason code", len(e.Lines))
+}
+
+// Checks if the subtype can be used in a Saída car
+func IsValidSubtype(subtype string) bool {
+	for _, valid := range SaidaSubtypes {
+		if subtype == valid {
+			return true
+		}
+	}
+	return false
+}
+
+// Checks if the reason code exists
+func IsValidReason(reason string) bool {
+	_, exists := WriteOffReasons[reason]
+	return exists
+}
+
+// Checks the reason of a line when it is added or edited, a line can still be without a reason
+// until the car is exported
+func checkLineReason(reason string) error {
+	if reason != "" && !IsValidReason(reason) {
+		return ErrInvalidReason
+	}
+	return nil
+}
+
+// Changes the subtype of a Saída car while it is open, only if the car is still in the version the user edited
+func SetCarSubtype(db *pgxpool.Pool, id_car string, version int, subtype string) error {
+	return PatchCar(db, id_car, version, CarPatch{Subtype: &subtype})
+}
+
+// Checks that every line of a write-off has a valid reason code, donations do not need one
+func checkWriteOffReasons(ctx context.Context, tx pgx.Tx, id_car string, subtype string) error {
+
+	if subtype == "" || subtype == SubtypeDonation {
+		return nil
+	}
+	if !IsValidSubtype(subtype) {
+		return ErrInvalidSubtype
+	}
+
+	query := `
+		SELECT id, reason
+		FROM products_car
+		WHERE id_car = $1
+		ORDER BY id
+	`
+	rows, err := tx.Query(ctx, query, id_car)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	missing := &MissingReasonError{Lines: []int{}}
+	for rows.Next() {
+		var id int
+		var reason string
+		if err := rows.Scan(&id, &reason); err != nil {
+			return err
+		}
+		if !IsValidReason(reason) {
+			missing.Lines = append(missing.Lines, id)
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	if len(missing.Lines) > 0 {
+		return missing
+	}
+	return nil
+}
+
+// Struct of the quantity of a product written off for a reason
+type WriteOffProduct struct {
+	IDProduct string  `json:"id_product"`
+	Name      string  `json:"name"`
+	Unit      string  `json:"unit"`
+	Quantity  float64 `json:"quantity"`
+}
+
+// Struct of the total written off for a reason
+type WriteOffTotal struct {
+	Reason      string            `json:"reason"`
+	Description string            `json:"description"`
+	Quantity    float64           `json:"quantity"`
+	Products    []WriteOffProduct `json:"products"`
+}
+
+// Gets the totals of the write-offs by reason and product, the dates (YYYY-MM-DD) are optional
+func GetWriteOffReport(db *pgxpool.Pool, from string, to string) ([]WriteOffTotal, error) {
+
+	// The movements of a Saída are negative, the report shows what left as positive
+	query := `
+		SELECT sm.reason, sm.id_product, p.name, p.unit, -SUM(sm.quantity)
+		FROM stock_movements sm
+		JOIN products p ON p.id_product = sm.id_product
+		WHERE sm.type = $1
+			AND sm.subtype NOT IN ('', $2)
+			AND ($3 = '' OR sm.created_at >= NULLIF($3, '')::date)
+			AND ($4 = '' OR sm.created_at < NULLIF($4, '')::date + INTERVAL '1 day')
+		GROUP BY sm.reason, sm.id_product, p.name, p.unit
+		ORDER BY sm.reason, sm.id_product
+	`
+
+	rows, err := db.Query(context.Background(), query, CarTypeSaida, SubtypeDonation, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	totals := []WriteOffTotal{}
+	for rows.Next() {
+		var reason string
+		var product WriteOffProduct
+		err := rows.Scan(&reason, &product.IDProduct, &product.Name, &product.Unit, &product.Quantity)
+		if err != nil {
+			return nil, err
+		}
+
+		// The rows come ordered by reason so the products of a reason are always together
+		if len(totals) == 0 || totals[len(totals)-1].Reason != reason {
+			totals = append(totals, WriteOffTotal{
+				Reason:      reason,
+				Description: WriteOffReasons[reason],
+				Products:    []WriteOffProduct{},
+			})
+		}
+		last := &totals[len(totals)-1]
+		last.Products = append(last.Products, product)
+		last.Quantity += product.Quantity
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// The reasons with more losses first
+	sort.SliceStable(totals, func(i, j int) bool {
+		return totals[i].Quantity > totals[j].Quantity
+	})
+
+	return totals, nil
+}
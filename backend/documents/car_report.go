@@ -0,0 +1,253 @@
+package documents
+
+import (
+	"bytes"
+	"image"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Samuel-k276/backend/database"
+)
+
+// Title of the report of a car
+const CarReportTitle = "Relatório Ajuda de Berço"
+
+// Names of the subtypes of a Saída shown in the report
+var subtypeNames = map[string]string{
+	database.SubtypeDonation:    "Doação",
+	database.SubtypeBreakage:    "Quebra",
+	database.SubtypeExpired:     "Fora de validade",
+	database.SubtypeRecall:      "Recolha do fabricante",
+	database.SubtypeInternalUse: "Uso interno",
+}
+
+// Layout of the table of products, in millimetres and points like the report made by the browser
+const (
+	tableMargin      = 14.0
+	tableFontSize    = 10.0
+	tableCellPadding = 1.76
+	tableLineHeight  = tableFontSize * 1.15 / pointsPerMM
+	tableBottom      = PageHeight - tableMargin
+)
+
+// Columns of the table of products and their width
+var tableColumns = []struct {
+	title string
+	width float64
+}{
+	{"Código", 26},
+	{"Nome", 37},
+	{"Qtd.", 15},
+	{"Unidade", 18},
+	{"Expira a", 22},
+	{"Descrição", 64},
+}
+
+// Colours of the table
+var (
+	tableHeadColor      = Color{66, 139, 202}
+	tableAlternateColor = Color{240, 240, 240}
+)
+
+// Information of the car that is not in the car itself
+type CarReportInfo struct {
+	Warehouse     string
+	WarehouseDest string
+	Logo          image.Image
+	Location      *time.Location
+}
+
+// Builds the PDF report of an exported car
+// Everything comes from the car, so the same car always gives the same document
+func CarReportPDF(car *database.Car, info CarReportInfo) ([]byte, error) {
+
+	exported := time.Time{}
+	if car.ExportedAt != nil {
+		exported = *car.ExportedAt
+	}
+	location := info.Location
+	if location == nil {
+		location = time.UTC
+	}
+
+	pdf := NewPDF(CarReportTitle+" "+car.ID, exported.Format("20060102150405"))
+	pdf.AddPage()
+
+	if info.Logo != nil {
+		pdf.Image(info.Logo, 5, 5, 40, 17)
+	}
+
+	pdf.SetFont(false, 18)
+	pdf.TextCentered(PageWidth/2, 20, CarReportTitle)
+
+	pdf.SetFont(false, 10)
+	pdf.TextCentered(PageWidth/2, 27, joinFields(field("Documento N.º", car.DocumentNumber), "Exportação: "+exported.In(location).Format(DateTimeLayout)))
+
+	pdf.SetFont(false, 12)
+	pdf.TextCentered(PageWidth/2, 33, "Tipo: "+carTypeName(car))
+
+	y := 45.0
+	for _, line := range carReportHeader(car, info, exported.In(location)) {
+		pdf.Text(20, y, line)
+		y += 7
+	}
+	y += 10
+
+	writeProductsTable(pdf, sortedProducts(car.Products), y)
+
+	var out bytes.Buffer
+	if _, err := pdf.WriteTo(&out); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// Type of the car as it is shown in the report
+func carTypeName(car *database.Car) string {
+	if name, ok := subtypeNames[car.Subtype]; ok && car.Type == database.CarTypeSaida {
+		return car.Type + " - " + name
+	}
+	return car.Type
+}
+
+// Lines of the export form shown before the table, the empty fields are left out
+func carReportHeader(car *database.Car, info CarReportInfo, exported time.Time) []string {
+
+	date := exported.Format(DateLayout)
+	if car.DocumentDate != "" {
+		date = FormatDate(car.DocumentDate)
+	}
+
+	lines := []string{}
+	if car.Type == database.CarTypeEntrada {
+		lines = append(lines, joinFields("Data: "+date, field("Nome Doador", car.DonorName)))
+		if car.CountedBy != "" {
+			lines = append(lines, "Contado Por: "+car.CountedBy)
+		}
+		return lines
+	}
+
+	lines = append(lines, joinFields("Data: "+date, field("Armazém", info.Warehouse), field("Destino", info.WarehouseDest)))
+
+	if people := joinFields(field("Destinatário", car.Recipient), field("Realizado por", car.PerformedBy)); people != "" {
+		lines = append(lines, people)
+	}
+	if car.CountedBy != "" {
+		lines = append(lines, "Contado Por: "+car.CountedBy)
+	}
+	if car.Reason != "" {
+		lines = append(lines, "Motivo: "+car.Reason)
+	}
+
+	return lines
+}
+
+// Writes "name: value", or nothing when there is no value
+func field(name string, value string) string {
+	if value == "" {
+		return ""
+	}
+	return name + ": " + value
+}
+
+// Joins the fields that are not empty
+func joinFields(fields ...string) string {
+	filled := []string{}
+	for _, f := range fields {
+		if f != "" {
+			filled = append(filled, f)
+		}
+	}
+	return strings.Join(filled, " | ")
+}
+
+// Orders the products by code, and then by expiration so the order never changes
+func sortedProducts(products []database.Car_Product) []database.Car_Product {
+	sorted := append([]database.Car_Product{}, products...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].IDProduct != sorted[j].IDProduct {
+			return sorted[i].IDProduct < sorted[j].IDProduct
+		}
+		if sorted[i].Expiration != sorted[j].Expiration {
+			return sorted[i].Expiration < sorted[j].Expiration
+		}
+		return sorted[i].ID < sorted[j].ID
+	})
+	return sorted
+}
+
+// Writes the table of products starting at y, the head is repeated on every page
+func writeProductsTable(pdf *PDF, products []database.Car_Product, y float64) {
+
+	pdf.SetLineWidth(0.05)
+	pdf.SetDrawColor(Black)
+
+	head := []string{}
+	for _, column := range tableColumns {
+		head = append(head, column.title)
+	}
+	y = writeTableRow(pdf, head, y, true, tableHeadColor)
+
+	for i, product := range products {
+		row := []string{
+			product.IDProduct,
+			product.Name,
+			FormatNumber(product.Quantity),
+			product.Unit,
+			FormatDate(product.Expiration),
+			product.Description,
+		}
+
+		fill := White
+		if i%2 == 1 {
+			fill = tableAlternateColor
+		}
+
+		// A row that does not fit goes to the next page, after the head
+		if y+tableRowHeight(pdf, row) > tableBottom {
+			pdf.AddPage()
+			y = writeTableRow(pdf, head, tableMargin, true, tableHeadColor)
+		}
+		y = writeTableRow(pdf, row, y, false, fill)
+	}
+}
+
+// Height of a row, the cells grow with the lines of their text
+func tableRowHeight(pdf *PDF, row []string) float64 {
+	pdf.SetFont(false, tableFontSize)
+	lines := 1
+	for i, text := range row {
+		lines = max(lines, len(pdf.SplitText(text, tableColumns[i].width-2*tableCellPadding)))
+	}
+	return float64(lines)*tableLineHeight + 2*tableCellPadding
+}
+
+// Writes a row of the table at y and returns where the next row starts
+func writeTableRow(pdf *PDF, row []string, y float64, head bool, fill Color) float64 {
+
+	height := tableRowHeight(pdf, row)
+	pdf.SetFont(head, tableFontSize)
+	pdf.SetFillColor(fill)
+	pdf.SetTextColor(Black)
+	if head {
+		pdf.SetTextColor(White)
+	}
+
+	// Baseline of the first line, centred in the height of the line
+	fontHeight := tableFontSize / pointsPerMM
+	baseline := y + tableCellPadding + (tableLineHeight+fontHeight)/2 - fontHeight*0.2
+
+	x := tableMargin
+	for i, text := range row {
+		width := tableColumns[i].width
+		pdf.Rect(x, y, width, height, "FD")
+		for j, line := range pdf.SplitText(text, width-2*tableCellPadding) {
+			pdf.TextCentered(x+width/2, baseline+float64(j)*tableLineHeight, line)
+		}
+		x += width
+	}
+
+	pdf.SetTextColor(Black)
+	return y + height
+}
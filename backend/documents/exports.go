@@ -0,0 +1,141 @@
+package documents
+
+import (
+	"time"
+
+	"github.com/Samuel-k276/backend/database"
+	"github.com/Samuel-k276/backend/models"
+)
+
+// Builds the sheet with the products of a car, in the same order as its report
+func CarSheet(car *database.Car) Sheet {
+
+	sheet := Sheet{
+		Name:    "Carrinho " + car.ID,
+		Columns: []string{"Código", "Nome", "Qtd.", "Unidade", "Expira a", "Descrição", "Motivo"},
+		Rows:    [][]interface{}{},
+	}
+
+	for _, product := range sortedProducts(car.Products) {
+		sheet.Rows = append(sheet.Rows, []interface{}{
+			product.IDProduct,
+			product.Name,
+			product.Quantity,
+			product.Unit,
+			dateCell(product.Expiration),
+			product.Description,
+			reasonName(product.Reason),
+		})
+	}
+
+	return sheet
+}
+
+// Builds the sheet with the lines of the cars exported in a period, one row per line
+func ExportedCarsSheet(lines []database.ExportedCarLine, location *time.Location) Sheet {
+
+	sheet := Sheet{
+		Name: "Carrinhos exportados",
+		Columns: []string{
+			"Carrinho", "Documento", "Tipo", "Subtipo", "Armazém", "Exportado em", "Data", "Doador", "Destinatário",
+			"Código", "Nome", "Qtd.", "Unidade", "Expira a", "Descrição", "Motivo",
+		},
+		Rows: [][]interface{}{},
+	}
+
+	for _, line := range lines {
+		sheet.Rows = append(sheet.Rows, []interface{}{
+			line.IDCar,
+			line.DocumentNumber,
+			line.Type,
+			subtypeNames[line.Subtype],
+			line.Warehouse,
+			line.ExportedAt.In(location),
+			dateCell(line.DocumentDate),
+			line.DonorName,
+			line.Recipient,
+			line.IDProduct,
+			line.Name,
+			line.Quantity,
+			line.Unit,
+			dateCell(line.Expiration),
+			line.Description,
+			reasonName(line.Reason),
+		})
+	}
+
+	return sheet
+}
+
+// Builds the sheet of the stock on hand, one row per lot
+func StockSheet(stock []database.ProductStock) Sheet {
+
+	sheet := Sheet{
+		Name:    "Stock",
+		Columns: []string{"Código", "Nome", "Categoria", "Unidade", "Posição X", "Posição Y", "Expira a", "Qtd."},
+		Rows:    [][]interface{}{},
+	}
+
+	for _, product := range stock {
+		for _, lot := range product.Lots {
+			sheet.Rows = append(sheet.Rows, []interface{}{
+				product.IDProduct,
+				product.Name,
+				models.ProductCategory(product.IDProduct),
+				product.Unit,
+				float64(product.Pos_x),
+				float64(product.Pos_y),
+				dateCell(lot.Expiration),
+				lot.Quantity,
+			})
+		}
+	}
+
+	return sheet
+}
+
+// Builds the sheet of the movements of the ledger
+func MovementsSheet(movements []database.StockMovement, location *time.Location) Sheet {
+
+	sheet := Sheet{
+		Name:    "Movimentos",
+		Columns: []string{"Data", "Carrinho", "Tipo", "Subtipo", "Motivo", "Armazém", "Código", "Expira a", "Qtd."},
+		Rows:    [][]interface{}{},
+	}
+
+	for _, movement := range movements {
+		sheet.Rows = append(sheet.Rows, []interface{}{
+			movement.CreatedAt.In(location),
+			movement.IDCar,
+			movement.Type,
+			subtypeNames[movement.Subtype],
+			reasonName(movement.Reason),
+			movement.Warehouse,
+			movement.IDProduct,
+			dateCell(movement.Expiration),
+			movement.Quantity,
+		})
+	}
+
+	return sheet
+}
+
+// Cell of a date stored as YYYY-MM-DD, empty dates stay empty and dates that can not be read stay as text
+func dateCell(date string) interface{} {
+	if date == "" {
+		return nil
+	}
+	parsed, err := time.Parse(database.ExpirationLayout, date)
+	if err != nil {
+		return date
+	}
+	return parsed
+}
+
+// Description of the reason code of a write-off, codes that do not exist are shown as they are
+func reasonName(reason string) string {
+	if name, ok := database.WriteOffReasons[reason]; ok {
+		return name
+	}
+	return reason
+}
@@ -0,0 +1,35 @@
+package documents
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Samuel-k276/backend/database"
+)
+
+// Formats used in the documents, the Portuguese way
+const (
+	DateLayout     = "02/01/2006"
+	DateTimeLayout = "02/01/2006 15:04"
+)
+
+// Writes the number with a decimal comma and without zeros at the end
+func FormatNumber(value float64) string {
+	return strings.Replace(formatQuantity(value), ".", ",", 1)
+}
+
+// Writes the number with the digits it had in the database, the quantities are stored as REAL
+// so a 0.1 comes back as 0.10000000149011612 if it is written with all the digits of a float64
+func formatQuantity(value float64) string {
+	return strconv.FormatFloat(value, 'f', -1, 32)
+}
+
+// Writes a date stored as YYYY-MM-DD as dd/mm/yyyy, dates that can not be read are written as they are
+func FormatDate(date string) string {
+	parsed, err := time.Parse(database.ExpirationLayout, date)
+	if err != nil {
+		return date
+	}
+	return parsed.Format(DateLayout)
+}
@@ -0,0 +1,44 @@
+package documents
+
+import "testing"
+
+func TestFormatNumber(t *testing.T) {
+	tests := []struct {
+		value float64
+		want  string
+	}{
+		{0, "0"},
+		{3, "3"},
+		{2.5, "2,5"},
+		{-1.25, "-1,25"},
+		{1000, "1000"},
+		// The quantities are stored as REAL and come back with the digits of a float32
+		{float64(float32(0.1)), "0,1"},
+		{float64(float32(2.3)), "2,3"},
+	}
+
+	for _, test := range tests {
+		if got := FormatNumber(test.value); got != test.want {
+			t.Errorf("FormatNumber(%v) = %q, want %q", test.value, got, test.want)
+		}
+	}
+}
+
+func TestFormatDate(t *testing.T) {
+	tests := []struct {
+		date string
+		want string
+	}{
+		{"2026-05-10", "10/05/2026"},
+		{"2025-12-31", "31/12/2025"},
+		{"", ""},
+		{"10/05/2026", "10/05/2026"},
+		{"2026-02-30", "2026-02-30"},
+	}
+
+	for _, test := range tests {
+		if got := FormatDate(test.date); got != test.want {
+			t.Errorf("FormatDate(%q) = %q, want %q", test.date, got, test.want)
+		}
+	}
+}
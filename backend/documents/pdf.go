@@ -0,0 +1,352 @@
+package documents
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"image"
+	"io"
+	"strings"
+)
+
+// Size of an A4 page in millimetres, every position in a PDF is given in millimetres from the top left corner
+const (
+	PageWidth  = 210.0
+	PageHeight = 297.0
+)
+
+// Name written in the properties of the documents
+const producer = "Ajuda de Berço"
+
+// Points in a millimetre, the unit used inside the PDF
+const pointsPerMM = 72 / 25.4
+
+// Struct of a colour with the values from 0 to 255
+type Color struct {
+	R, G, B uint8
+}
+
+// Colours used by the documents
+var (
+	Black = Color{0, 0, 0}
+	White = Color{255, 255, 255}
+)
+
+// Struct of an image added to the PDF, kept already compressed
+type pdfImage struct {
+	width, height int
+	rgb           []byte
+	alpha         []byte
+}
+
+// Struct of a PDF being written, it only knows what the reports need:
+// text in Helvetica, rectangles, lines and images
+type PDF struct {
+	pages  []*bytes.Buffer
+	page   *bytes.Buffer
+	images []pdfImage
+
+	bold      bool
+	fontSize  float64
+	textColor Color
+	fillColor Color
+	drawColor Color
+	lineWidth float64
+
+	title   string
+	created string
+}
+
+// Creates an empty PDF, the title and date (YYYYMMDDHHmmSS) go to the properties of the document
+// The date is given so the same document is always written the same way
+func NewPDF(title string, created string) *PDF {
+	return &PDF{
+		fontSize:  12,
+		textColor: Black,
+		fillColor: White,
+		drawColor: Black,
+		lineWidth: 0.2,
+		title:     title,
+		created:   created,
+	}
+}
+
+// Starts a new page, everything drawn after goes to it
+func (p *PDF) AddPage() {
+	p.page = &bytes.Buffer{}
+	p.pages = append(p.pages, p.page)
+}
+
+// Number of pages already started
+func (p *PDF) PageCount() int {
+	return len(p.pages)
+}
+
+// Changes the font used by the text, size in points
+func (p *PDF) SetFont(bold bool, size float64) {
+	p.bold = bold
+	p.fontSize = size
+}
+
+// Size of the font in points
+func (p *PDF) FontSize() float64 {
+	return p.fontSize
+}
+
+// Changes the colour of the text
+func (p *PDF) SetTextColor(color Color) {
+	p.textColor = color
+}
+
+// Changes the colour that fills the rectangles
+func (p *PDF) SetFillColor(color Color) {
+	p.fillColor = color
+}
+
+// Changes the colour of the lines and borders
+func (p *PDF) SetDrawColor(color Color) {
+	p.drawColor = color
+}
+
+// Changes the width of the lines and borders in millimetres
+func (p *PDF) SetLineWidth(width float64) {
+	p.lineWidth = width
+}
+
+// Writes the text with its baseline at y and starting at x
+func (p *PDF) Text(x float64, y float64, text string) {
+	font := "F1"
+	if p.bold {
+		font = "F2"
+	}
+	fmt.Fprintf(p.page, "BT /%s %.2f Tf %s rg %.2f %.2f Td (%s) Tj ET\n",
+		font, p.fontSize, colorOperands(p.textColor), x*pointsPerMM, (PageHeight-y)*pointsPerMM, escapeText(encodeWinAnsi(text)))
+}
+
+// Writes the text centred on x
+func (p *PDF) TextCentered(x float64, y float64, text string) {
+	p.Text(x-p.TextWidth(text)/2, y, text)
+}
+
+// Draws a rectangle, style is "F" to fill, "D" to draw the border or "FD" for both
+func (p *PDF) Rect(x float64, y float64, width float64, height float64, style string) {
+	operator := map[string]string{"F": "f", "D": "S", "FD": "B"}[style]
+	if operator == "" {
+		operator = "S"
+	}
+	fmt.Fprintf(p.page, "%.3f w %s RG %s rg %.2f %.2f %.2f %.2f re %s\n",
+		p.lineWidth*pointsPerMM, colorOperands(p.drawColor), colorOperands(p.fillColor),
+		x*pointsPerMM, (PageHeight-y-height)*pointsPerMM, width*pointsPerMM, height*pointsPerMM, operator)
+}
+
+// Draws a line between two points
+func (p *PDF) Line(x1 float64, y1 float64, x2 float64, y2 float64) {
+	fmt.Fprintf(p.page, "%.3f w %s RG %.2f %.2f m %.2f %.2f l S\n",
+		p.lineWidth*pointsPerMM, colorOperands(p.drawColor),
+		x1*pointsPerMM, (PageHeight-y1)*pointsPerMM, x2*pointsPerMM, (PageHeight-y2)*pointsPerMM)
+}
+
+// Draws the image stretched to the rectangle
+func (p *PDF) Image(img image.Image, x float64, y float64, width float64, height float64) {
+
+	bounds := img.Bounds()
+	data := pdfImage{width: bounds.Dx(), height: bounds.Dy()}
+
+	// The colours and the transparency go in separate streams
+	transparent := false
+	for py := bounds.Min.Y; py < bounds.Max.Y; py++ {
+		for px := bounds.Min.X; px < bounds.Max.X; px++ {
+			r, g, b, a := img.At(px, py).RGBA()
+			// The colours come premultiplied by the transparency
+			if a > 0 && a < 0xffff {
+				r, g, b = r*0xffff/a, g*0xffff/a, b*0xffff/a
+			}
+			data.rgb = append(data.rgb, byte(r>>8), byte(g>>8), byte(b>>8))
+			data.alpha = append(data.alpha, byte(a>>8))
+			if a != 0xffff {
+				transparent = true
+			}
+		}
+	}
+	if !transparent {
+		data.alpha = nil
+	}
+
+	p.images = append(p.images, data)
+	fmt.Fprintf(p.page, "q %.2f 0 0 %.2f %.2f %.2f cm /I%d Do Q\n",
+		width*pointsPerMM, height*pointsPerMM, x*pointsPerMM, (PageHeight-y-height)*pointsPerMM, len(p.images))
+}
+
+// Width of the text in millimetres with the current font
+func (p *PDF) TextWidth(text string) float64 {
+	widths := helveticaWidths
+	if p.bold {
+		widths = helveticaBoldWidths
+	}
+
+	total := 0
+	encoded := encodeWinAnsi(text)
+	for i := 0; i < len(encoded); i++ {
+		total += glyphWidth(widths, encoded[i])
+	}
+	return float64(total) * p.fontSize / 1000 / pointsPerMM
+}
+
+// Splits the text in lines that fit in the width, words longer than the width are cut
+func (p *PDF) SplitText(text string, width float64) []string {
+
+	lines := []string{}
+	for _, paragraph := range strings.Split(text, "\n") {
+		line := ""
+		for _, word := range strings.Fields(paragraph) {
+			candidate := word
+			if line != "" {
+				candidate = line + " " + word
+			}
+			if p.TextWidth(candidate) <= width {
+				line = candidate
+				continue
+			}
+			if line != "" {
+				lines = append(lines, line)
+			}
+
+			// A word that does not fit alone is cut where it reaches the width
+			line = ""
+			for _, c := range word {
+				if line != "" && p.TextWidth(line+string(c)) > width {
+					lines = append(lines, line)
+					line = ""
+				}
+				line += string(c)
+			}
+		}
+		lines = append(lines, line)
+	}
+
+	return lines
+}
+
+// Writes the document, the objects are always written in the same order so the same content gives the same bytes
+func (p *PDF) WriteTo(w io.Writer) (int64, error) {
+
+	var out bytes.Buffer
+	offsets := []int{}
+
+	// Every object is numbered by the order it is written, starting at 1
+	object := func(body string) {
+		offsets = append(offsets, out.Len())
+		fmt.Fprintf(&out, "%d 0 obj\n%s\nendobj\n", len(offsets), body)
+	}
+	stream := func(dict string, data []byte) {
+		offsets = append(offsets, out.Len())
+		fmt.Fprintf(&out, "%d 0 obj\n<< %s /Length %d >>\nstream\n", len(offsets), dict, len(data))
+		out.Write(data)
+		out.WriteString("\nendstream\nendobj\n")
+	}
+
+	out.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+
+	// 1 catalog, 2 page tree, 3 info, 4 and 5 fonts, then the images and then a content and a page object for each page
+	firstImage := 6
+	imageObjects := []int{}
+	next := firstImage
+	for _, img := range p.images {
+		imageObjects = append(imageObjects, next)
+		next++
+		if img.alpha != nil {
+			next++
+		}
+	}
+	firstPage := next
+
+	kids := []string{}
+	for i := range p.pages {
+		kids = append(kids, fmt.Sprintf("%d 0 R", firstPage+2*i+1))
+	}
+
+	object("<< /Type /Catalog /Pages 2 0 R >>")
+	object(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(p.pages)))
+	object(fmt.Sprintf("<< /Title (%s) /Producer (%s) /CreationDate (D:%s) >>",
+		escapeText(encodeWinAnsi(p.title)), escapeText(encodeWinAnsi(producer)), p.created))
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>")
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>")
+
+	for i, img := range p.images {
+		mask := ""
+		if img.alpha != nil {
+			mask = fmt.Sprintf(" /SMask %d 0 R", imageObjects[i]+1)
+		}
+		stream(fmt.Sprintf("/Type /XObject /Subtype /Image /Width %d /Height %d /ColorSpace /DeviceRGB /BitsPerComponent 8 /Filter /FlateDecode%s",
+			img.width, img.height, mask), deflate(img.rgb))
+		if img.alpha != nil {
+			stream(fmt.Sprintf("/Type /XObject /Subtype /Image /Width %d /Height %d /ColorSpace /DeviceGray /BitsPerComponent 8 /Filter /FlateDecode",
+				img.width, img.height), deflate(img.alpha))
+		}
+	}
+
+	xobjects := []string{}
+	for i, number := range imageObjects {
+		xobjects = append(xobjects, fmt.Sprintf("/I%d %d 0 R", i+1, number))
+	}
+	resources := fmt.Sprintf("<< /Font << /F1 4 0 R /F2 5 0 R >> /XObject << %s >> >>", strings.Join(xobjects, " "))
+
+	for i, page := range p.pages {
+		stream("/Filter /FlateDecode", deflate(page.Bytes()))
+		object(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %.2f %.2f] /Resources %s /Contents %d 0 R >>",
+			PageWidth*pointsPerMM, PageHeight*pointsPerMM, resources, firstPage+2*i))
+	}
+
+	xref := out.Len()
+	fmt.Fprintf(&out, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&out, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&out, "trailer\n<< /Size %d /Root 1 0 R /Info 3 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+
+	return out.WriteTo(w)
+}
+
+// Compresses the data of a stream
+func deflate(data []byte) []byte {
+	var out bytes.Buffer
+	writer := zlib.NewWriter(&out)
+	writer.Write(data)
+	writer.Close()
+	return out.Bytes()
+}
+
+// Values of a colour as the PDF operators want them
+func colorOperands(color Color) string {
+	return fmt.Sprintf("%.3f %.3f %.3f", float64(color.R)/255, float64(color.G)/255, float64(color.B)/255)
+}
+
+// Escapes the characters that have a meaning inside a PDF string
+func escapeText(text string) string {
+	replacer := strings.NewReplacer(`\`, `\\`, `(`, `\(`, `)`, `\)`, "\r", `\r`, "\n", `\n`)
+	return replacer.Replace(text)
+}
+
+// Characters of Windows-1252 that are not in the same place as in Unicode
+var winAnsiExtra = map[rune]byte{
+	'€': 0x80, '‚': 0x82, 'ƒ': 0x83, '„': 0x84, '…': 0x85, '†': 0x86, '‡': 0x87, 'ˆ': 0x88,
+	'‰': 0x89, 'Š': 0x8a, '‹': 0x8b, 'Œ': 0x8c, 'Ž': 0x8e, '‘': 0x91, '’': 0x92, '“': 0x93,
+	'”': 0x94, '•': 0x95, '–': 0x96, '—': 0x97, '˜': 0x98, '™': 0x99, 'š': 0x9a, '›': 0x9b,
+	'œ': 0x9c, 'ž': 0x9e, 'Ÿ': 0x9f,
+}
+
+// Converts the text to the encoding of the standard fonts, characters that do not exist there become '?'
+func encodeWinAnsi(text string) string {
+	out := make([]byte, 0, len(text))
+	for _, c := range text {
+		switch {
+		case c < 0x80 || (c >= 0xa0 && c <= 0xff):
+			out = append(out, byte(c))
+		case winAnsiExtra[c] != 0:
+			out = append(out, winAnsiExtra[c])
+		default:
+			out = append(out, '?')
+		}
+	}
+	return string(out)
+}
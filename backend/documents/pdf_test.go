@@ -0,0 +1,225 @@
+package documents
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// Writes a PDF with text, shapes and images, with and without transparency
+func samplePDF() []byte {
+	pdf := NewPDF("Relatório (teste)", "20260101120000")
+
+	opaque := image.NewRGBA(image.Rect(0, 0, 2, 2))
+	transparent := image.NewNRGBA(image.Rect(0, 0, 2, 2))
+	for y := 0; y < 2; y++ {
+		for x := 0; x < 2; x++ {
+			opaque.Set(x, y, color.RGBA{200, 10, 10, 255})
+			transparent.Set(x, y, color.NRGBA{10, 10, 200, 128})
+		}
+	}
+
+	pdf.AddPage()
+	pdf.SetFont(true, 14)
+	pdf.Text(20, 20, "Olá (mundo) \\ €")
+	pdf.Rect(20, 30, 50, 10, "FD")
+	pdf.Image(opaque, 20, 50, 10, 10)
+
+	pdf.AddPage()
+	pdf.SetFont(false, 10)
+	pdf.Line(20, 20, 100, 20)
+	pdf.Image(transparent, 20, 50, 10, 10)
+	pdf.TextCentered(PageWidth/2, 40, "Página 2")
+
+	var out bytes.Buffer
+	pdf.WriteTo(&out)
+	return out.Bytes()
+}
+
+func TestPDFXrefOffsets(t *testing.T) {
+	data := samplePDF()
+
+	match := regexp.MustCompile(`startxref\n(\d+)\n%%EOF\n$`).FindSubmatch(data)
+	if match == nil {
+		t.Fatalf("no startxref at the end of the file")
+	}
+	xref, _ := strconv.Atoi(string(match[1]))
+	if !bytes.HasPrefix(data[xref:], []byte("xref\n")) {
+		t.Fatalf("startxref %d does not point to the xref table", xref)
+	}
+
+	var first, count int
+	table := string(data[xref:])
+	if _, err := fmt.Sscanf(table, "xref\n%d %d\n", &first, &count); err != nil {
+		t.Fatalf("reading the xref header: %v", err)
+	}
+	if first != 0 {
+		t.Fatalf("xref starts at object %d, want 0", first)
+	}
+
+	entries := regexp.MustCompile(`(\d{10}) (\d{5}) ([fn]) \n`).FindAllStringSubmatch(table, -1)
+	if len(entries) != count {
+		t.Fatalf("xref has %d entries, the header says %d", len(entries), count)
+	}
+	if entries[0][3] != "f" {
+		t.Errorf("object 0 must be free")
+	}
+
+	// Every entry points to the start of its own object
+	for number, entry := range entries[1:] {
+		offset, _ := strconv.Atoi(entry[1])
+		want := fmt.Sprintf("%d 0 obj\n", number+1)
+		if !bytes.HasPrefix(data[offset:], []byte(want)) {
+			t.Errorf("offset %d of object %d points to %q", offset, number+1, data[offset:min(offset+20, len(data))])
+		}
+	}
+
+	if !bytes.Contains(data, []byte(fmt.Sprintf("/Size %d ", count))) {
+		t.Errorf("trailer /Size is not %d", count)
+	}
+}
+
+func TestPDFStreams(t *testing.T) {
+	data := samplePDF()
+
+	// The length of every stream is the number of bytes between stream and endstream
+	streams := regexp.MustCompile(`(?s)/Length (\d+) >>\nstream\n`).FindAllSubmatchIndex(data, -1)
+	if len(streams) != 5 {
+		t.Fatalf("found %d streams, want 5 (2 pages, an image and an image with its mask)", len(streams))
+	}
+
+	contents := []string{}
+	for _, stream := range streams {
+		length, _ := strconv.Atoi(string(data[stream[2]:stream[3]]))
+		start := stream[1]
+		if !bytes.HasPrefix(data[start+length:], []byte("\nendstream\n")) {
+			t.Fatalf("stream at %d is not %d bytes long", start, length)
+		}
+
+		reader, err := zlib.NewReader(bytes.NewReader(data[start : start+length]))
+		if err != nil {
+			t.Fatalf("stream at %d is not compressed: %v", start, err)
+		}
+		content, err := io.ReadAll(reader)
+		if err != nil {
+			t.Fatalf("reading stream at %d: %v", start, err)
+		}
+		contents = append(contents, string(content))
+	}
+
+	// The text is escaped and in Windows-1252
+	all := strings.Join(contents, "\n")
+	if !strings.Contains(all, "(Ol\xe1 \\(mundo\\) \\\\ \x80) Tj") {
+		t.Errorf("text of the first page not found in the streams")
+	}
+	if !strings.Contains(all, "/F2 14.00 Tf") || !strings.Contains(all, "/F1 10.00 Tf") {
+		t.Errorf("fonts of the pages not found in the streams")
+	}
+	if !bytes.Contains(data, []byte("/Title (Relat\xf3rio \\(teste\\))")) {
+		t.Errorf("title is not escaped in the properties")
+	}
+	if !bytes.Contains(data, []byte("/Count 2")) {
+		t.Errorf("page tree does not have 2 pages")
+	}
+}
+
+func TestEscapeText(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"", ""},
+		{"texto simples", "texto simples"},
+		{"(entre parênteses)", `\(entre parênteses\)`},
+		{`C:\pasta`, `C:\\pasta`},
+		{`\(`, `\\\(`},
+		{"duas\nlinhas\r", `duas\nlinhas\r`},
+	}
+
+	for _, test := range tests {
+		if got := escapeText(test.text); got != test.want {
+			t.Errorf("escapeText(%q) = %q, want %q", test.text, got, test.want)
+		}
+	}
+}
+
+func TestEncodeWinAnsi(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"", ""},
+		{"ABC 123", "ABC 123"},
+		{"Olá", "Ol\xe1"},
+		{"Doação", "Doa\xe7\xe3o"},
+		{"ÿ", "\xff"},
+		{"10 €", "10 \x80"},
+		{"“aspas” – …", "\x93aspas\x94 \x96 \x85"},
+		{"Œuvre", "\x8cuvre"},
+		{"日本", "??"},
+		{"\u0080", "?"},
+		{"😀", "?"},
+	}
+
+	for _, test := range tests {
+		if got := encodeWinAnsi(test.text); got != test.want {
+			t.Errorf("encodeWinAnsi(%q) = %q, want %q", test.text, got, test.want)
+		}
+	}
+}
+
+func TestSplitText(t *testing.T) {
+	pdf := NewPDF("", "")
+	pdf.SetFont(false, 10)
+
+	long := strings.Repeat("M", 40)
+	tests := []struct {
+		name  string
+		text  string
+		width float64
+		want  []string
+	}{
+		{"empty", "", 50, []string{""}},
+		{"fits", "arroz e massa", 50, []string{"arroz e massa"}},
+		{"wraps words", "arroz massa feijão", pdf.TextWidth("arroz massa"), []string{"arroz massa", "feijão"}},
+		{"keeps paragraphs", "um\ndois", 50, []string{"um", "dois"}},
+		{"joins spaces", "  um   dois  ", 50, []string{"um dois"}},
+		{"cuts a long word", long, pdf.TextWidth("MMMMMMMMMM"), []string{"MMMMMMMMMM", "MMMMMMMMMM", "MMMMMMMMMM", "MMMMMMMMMM"}},
+		{"long word after a short one", "a " + long[:15], pdf.TextWidth("MMMMMMMMMM"), []string{"a", "MMMMMMMMMM", "MMMMM"}},
+		{"narrower than a letter", "abc", 0.1, []string{"a", "b", "c"}},
+	}
+
+	for _, test := range tests {
+		got := pdf.SplitText(test.text, test.width)
+		if strings.Join(got, "|") != strings.Join(test.want, "|") {
+			t.Errorf("%s: SplitText(%q) = %q, want %q", test.name, test.text, got, test.want)
+		}
+	}
+}
+
+func TestSplitTextFitsWidth(t *testing.T) {
+	pdf := NewPDF("", "")
+
+	text := "Embalagem danificada " + strings.Repeat("Supercalifragilístico", 5) + " fim"
+	for _, width := range []float64{15, 30, 60} {
+		lines := pdf.SplitText(text, width)
+		for _, line := range lines {
+			if pdf.TextWidth(line) > width {
+				t.Errorf("width %v: line %q is %.2fmm wide", width, line, pdf.TextWidth(line))
+			}
+		}
+
+		// Nothing is lost when the words are cut
+		joined := strings.ReplaceAll(strings.Join(lines, ""), " ", "")
+		if joined != strings.ReplaceAll(text, " ", "") {
+			t.Errorf("width %v: lines %q do not have all the text", width, lines)
+		}
+	}
+}
@@ -0,0 +1,266 @@
+package documents
+
+import (
+	"archive/zip"
+	"encoding/csv"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Struct of a table that can be written as CSV or XLSX
+// The cells can be a string, a float64 or a time.Time, a nil cell is left empty
+type Sheet struct {
+	Name    string
+	Columns []string
+	Rows    [][]interface{}
+}
+
+// Byte order mark, without it Excel reads the CSV files as Windows-1252
+const utf8BOM = "\xef\xbb\xbf"
+
+// Writes the sheet as CSV the way Excel opens it in Portugal: separated by ';', decimal comma and dates dd/mm/yyyy
+func WriteCSV(w io.Writer, sheet Sheet) error {
+
+	if _, err := io.WriteString(w, utf8BOM); err != nil {
+		return err
+	}
+
+	writer := csv.NewWriter(w)
+	writer.Comma = ';'
+	writer.UseCRLF = true
+
+	if err := writer.Write(sheet.Columns); err != nil {
+		return err
+	}
+
+	for _, row := range sheet.Rows {
+		record := make([]string, len(row))
+		for i, cell := range row {
+			record[i] = csvCell(cell)
+		}
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
+
+// Text of a cell in the CSV
+func csvCell(cell interface{}) string {
+	switch value := cell.(type) {
+	case nil:
+		return ""
+	case string:
+		return csvText(value)
+	case float64:
+		return FormatNumber(value)
+	case time.Time:
+		// Dates without time are written only as the date
+		if value.Hour() == 0 && value.Minute() == 0 && value.Second() == 0 {
+			return value.Format(DateLayout)
+		}
+		return value.Format(DateTimeLayout)
+	}
+	return fmt.Sprint(cell)
+}
+
+// Text typed by the users, Excel runs a cell that starts with = + - @ or a tab or CR as a formula
+// so those cells start with a ' that makes Excel read them as text
+func csvText(text string) string {
+	if text != "" && strings.ContainsRune("=+-@\t\r", rune(text[0])) {
+		return "'" + text
+	}
+	return text
+}
+
+// Styles of the cells of the XLSX, in the order they are in styles.xml
+const (
+	xlsxStyleDefault  = 0
+	xlsxStyleHead     = 1
+	xlsxStyleDate     = 2
+	xlsxStyleDateTime = 3
+)
+
+// Files of an XLSX that are always the same
+const (
+	xlsxContentTypes = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">
+<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>
+<Default Extension="xml" ContentType="application/xml"/>
+<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>
+<Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>
+<Override PartName="/xl/styles.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.styles+xml"/>
+</Types>`
+
+	xlsxRels = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>
+</Relationships>`
+
+	xlsxWorkbookRels = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/>
+<Relationship Id="rId2" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/styles" Target="styles.xml"/>
+</Relationships>`
+
+	xlsxStyles = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<styleSheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">
+<numFmts count="2">
+<numFmt numFmtId="164" formatCode="dd/mm/yyyy"/>
+<numFmt numFmtId="165" formatCode="dd/mm/yyyy hh:mm"/>
+</numFmts>
+<fonts count="2">
+<font><sz val="11"/><name val="Calibri"/></font>
+<font><b/><sz val="11"/><name val="Calibri"/></font>
+</fonts>
+<fills count="2">
+<fill><patternFill patternType="none"/></fill>
+<fill><patternFill patternType="gray125"/></fill>
+</fills>
+<borders count="1">
+<border><left/><right/><top/><bottom/><diagonal/></border>
+</borders>
+<cellStyleXfs count="1">
+<xf numFmtId="0" fontId="0" fillId="0" borderId="0"/>
+</cellStyleXfs>
+<cellXfs count="4">
+<xf numFmtId="0" fontId="0" fillId="0" borderId="0" xfId="0"/>
+<xf numFmtId="0" fontId="1" fillId="0" borderId="0" xfId="0" applyFont="1"/>
+<xf numFmtId="164" fontId="0" fillId="0" borderId="0" xfId="0" applyNumberFormat="1"/>
+<xf numFmtId="165" fontId="0" fillId="0" borderId="0" xfId="0" applyNumberFormat="1"/>
+</cellXfs>
+</styleSheet>`
+)
+
+// Writes the sheet as an XLSX workbook with a single sheet
+// The numbers and dates are real numbers and dates, so Excel shows them in the format of the computer
+func WriteXLSX(w io.Writer, sheet Sheet) error {
+
+	archive := zip.NewWriter(w)
+
+	files := []struct {
+		name    string
+		content string
+	}{
+		{"[Content_Types].xml", xlsxContentTypes},
+		{"_rels/.rels", xlsxRels},
+		{"xl/workbook.xml", xlsxWorkbook(sheet.Name)},
+		{"xl/_rels/workbook.xml.rels", xlsxWorkbookRels},
+		{"xl/styles.xml", xlsxStyles},
+		{"xl/worksheets/sheet1.xml", xlsxWorksheet(sheet)},
+	}
+
+	for _, file := range files {
+		writer, err := archive.Create(file.name)
+		if err != nil {
+			return err
+		}
+		if _, err = io.WriteString(writer, file.content); err != nil {
+			return err
+		}
+	}
+
+	return archive.Close()
+}
+
+// Workbook with the name of the only sheet
+func xlsxWorkbook(name string) string {
+	// Excel does not accept names of sheets longer than 31 characters or with some characters
+	name = strings.Map(func(c rune) rune {
+		if strings.ContainsRune(`\/?*[]:`, c) {
+			return '-'
+		}
+		return c
+	}, name)
+	if name == "" {
+		name = "Folha1"
+	}
+	if runes := []rune(name); len(runes) > 31 {
+		name = string(runes[:31])
+	}
+
+	return `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">
+<sheets><sheet name="` + xmlEscape(name) + `" sheetId="1" r:id="rId1"/></sheets>
+</workbook>`
+}
+
+// Worksheet with the head in the first row and the rows after it
+func xlsxWorksheet(sheet Sheet) string {
+
+	var out strings.Builder
+	out.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">
+<sheetData>`)
+
+	head := make([]interface{}, len(sheet.Columns))
+	for i, column := range sheet.Columns {
+		head[i] = column
+	}
+	writeXLSXRow(&out, 1, head, xlsxStyleHead)
+
+	for i, row := range sheet.Rows {
+		writeXLSXRow(&out, i+2, row, xlsxStyleDefault)
+	}
+
+	out.WriteString(`</sheetData>
+</worksheet>`)
+	return out.String()
+}
+
+// Writes a row of the worksheet, the number of the row starts at 1
+func writeXLSXRow(out *strings.Builder, number int, row []interface{}, style int) {
+
+	fmt.Fprintf(out, `<row r="%d">`, number)
+	for i, cell := range row {
+		ref := xlsxColumn(i) + fmt.Sprint(number)
+		switch value := cell.(type) {
+		case nil:
+			continue
+		case float64:
+			fmt.Fprintf(out, `<c r="%s" s="%d"><v>%s</v></c>`, ref, style, formatQuantity(value))
+		case time.Time:
+			dateStyle := xlsxStyleDateTime
+			if value.Hour() == 0 && value.Minute() == 0 && value.Second() == 0 {
+				dateStyle = xlsxStyleDate
+			}
+			fmt.Fprintf(out, `<c r="%s" s="%d"><v>%s</v></c>`, ref, dateStyle, strconv.FormatFloat(excelDate(value), 'f', -1, 64))
+		default:
+			fmt.Fprintf(out, `<c r="%s" s="%d" t="inlineStr"><is><t xml:space="preserve">%s</t></is></c>`, ref, style, xmlEscape(fmt.Sprint(value)))
+		}
+	}
+	out.WriteString(`</row>`)
+}
+
+// Letters of the column, 0 is A and 26 is AA
+func xlsxColumn(index int) string {
+	name := ""
+	for index >= 0 {
+		name = string(rune('A'+index%26)) + name
+		index = index/26 - 1
+	}
+	return name
+}
+
+// Day zero of the dates of Excel
+var excelEpoch = time.Date(1899, 12, 30, 0, 0, 0, 0, time.UTC)
+
+// Number of days since the day zero of Excel, the time is the fraction of the day
+// The date is read as it is shown, without converting the timezone
+func excelDate(date time.Time) float64 {
+	local := time.Date(date.Year(), date.Month(), date.Day(), date.Hour(), date.Minute(), date.Second(), 0, time.UTC)
+	return local.Sub(excelEpoch).Hours() / 24
+}
+
+// Escapes the text for the XML
+func xmlEscape(text string) string {
+	var out strings.Builder
+	xml.EscapeText(&out, []byte(text))
+	return out.String()
+}
@@ -0,0 +1,141 @@
+package documents
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Sheet with the kinds of cells the exports write
+func sampleSheet() Sheet {
+	return Sheet{
+		Name:    "Carrinho: E-2026/000123",
+		Columns: []string{"Código", "Quantidade", "Validade", "Exportado", "Descrição"},
+		Rows: [][]interface{}{
+			{"GAMR0001", 2.5, time.Date(2026, 5, 10, 0, 0, 0, 0, time.UTC), time.Date(2026, 5, 10, 14, 30, 0, 0, time.UTC), "caixa; aberta"},
+			{"PCPC00026", float64(float32(0.1)), nil, nil, `diz "olá"`},
+			{"GAMR0002", -3.0, nil, nil, "=1+1"},
+		},
+	}
+}
+
+func TestWriteCSV(t *testing.T) {
+	var out bytes.Buffer
+	if err := WriteCSV(&out, sampleSheet()); err != nil {
+		t.Fatalf("WriteCSV: %v", err)
+	}
+
+	want := utf8BOM +
+		"Código;Quantidade;Validade;Exportado;Descrição\r\n" +
+		"GAMR0001;2,5;10/05/2026;10/05/2026 14:30;\"caixa; aberta\"\r\n" +
+		"PCPC00026;0,1;;;\"diz \"\"olá\"\"\"\r\n" +
+		"GAMR0002;-3;;;'=1+1\r\n"
+	if out.String() != want {
+		t.Errorf("WriteCSV wrote\n%q\nwant\n%q", out.String(), want)
+	}
+}
+
+func TestCSVCellFormulas(t *testing.T) {
+	tests := []struct {
+		cell interface{}
+		want string
+	}{
+		{"", ""},
+		{"texto", "texto"},
+		{"=HYPERLINK(\"http://x\")", "'=HYPERLINK(\"http://x\")"},
+		{"+351 912 345 678", "'+351 912 345 678"},
+		{"-2", "'-2"},
+		{"@SUM(A1)", "'@SUM(A1)"},
+		{"\tescondido", "'\tescondido"},
+		{"\rescondido", "'\rescondido"},
+		{"a=b", "a=b"},
+		{-2.0, "-2"},
+	}
+
+	for _, test := range tests {
+		if got := csvCell(test.cell); got != test.want {
+			t.Errorf("csvCell(%q) = %q, want %q", test.cell, got, test.want)
+		}
+	}
+}
+
+func TestWriteXLSX(t *testing.T) {
+	var out bytes.Buffer
+	if err := WriteXLSX(&out, sampleSheet()); err != nil {
+		t.Fatalf("WriteXLSX: %v", err)
+	}
+
+	archive, err := zip.NewReader(bytes.NewReader(out.Bytes()), int64(out.Len()))
+	if err != nil {
+		t.Fatalf("the XLSX is not a zip: %v", err)
+	}
+
+	files := map[string]string{}
+	for _, file := range archive.File {
+		reader, err := file.Open()
+		if err != nil {
+			t.Fatalf("opening %s: %v", file.Name, err)
+		}
+		content, err := io.ReadAll(reader)
+		reader.Close()
+		if err != nil {
+			t.Fatalf("reading %s: %v", file.Name, err)
+		}
+		files[file.Name] = string(content)
+	}
+
+	for _, name := range []string{"[Content_Types].xml", "_rels/.rels", "xl/workbook.xml", "xl/_rels/workbook.xml.rels", "xl/styles.xml", "xl/worksheets/sheet1.xml"} {
+		if _, ok := files[name]; !ok {
+			t.Errorf("the XLSX has no %s", name)
+		}
+	}
+
+	// Excel does not accept : and / in the name of a sheet
+	if !strings.Contains(files["xl/workbook.xml"], `<sheet name="Carrinho- E-2026-000123"`) {
+		t.Errorf("name of the sheet not cleaned: %s", files["xl/workbook.xml"])
+	}
+
+	sheet := files["xl/worksheets/sheet1.xml"]
+	cells := []string{
+		`<c r="A1" s="1" t="inlineStr"><is><t xml:space="preserve">Código</t></is></c>`,
+		`<c r="B2" s="0"><v>2.5</v></c>`,
+		`<c r="C2" s="2"><v>46152</v></c>`,
+		`<c r="D2" s="3"><v>46152.604166666664</v></c>`,
+		`<c r="B3" s="0"><v>0.1</v></c>`,
+		`<c r="E3" s="0" t="inlineStr"><is><t xml:space="preserve">diz &#34;olá&#34;</t></is></c>`,
+		// A text cell is never a formula in the XLSX, so it is written as it is
+		`<c r="E4" s="0" t="inlineStr"><is><t xml:space="preserve">=1+1</t></is></c>`,
+	}
+	for _, cell := range cells {
+		if !strings.Contains(sheet, cell) {
+			t.Errorf("cell %s not found in the sheet", cell)
+		}
+	}
+	if strings.Contains(sheet, `r="C3"`) {
+		t.Errorf("empty cells must not be written")
+	}
+}
+
+func TestXLSXColumn(t *testing.T) {
+	tests := []struct {
+		index int
+		want  string
+	}{
+		{0, "A"},
+		{25, "Z"},
+		{26, "AA"},
+		{51, "AZ"},
+		{52, "BA"},
+		{701, "ZZ"},
+		{702, "AAA"},
+	}
+
+	for _, test := range tests {
+		if got := xlsxColumn(test.index); got != test.want {
+			t.Errorf("xlsxColumn(%d) = %q, want %q", test.index, got, test.want)
+		}
+	}
+}
@@ -117,3 +117,29 @@ func AuthMiddleware(next http.HandlerFunc) http.HandlerFunc {
 		next(w, r)
 	}
 }
+
+// AdminMiddleware is a middleware that only lets through JWT tokens with the admin role
+func AdminMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Extract token from header
+		tokenString := auth.ExtractTokenFromRequest(r)
+		if tokenString == "" {
+			http.Error(w, "Authentication token not provided", http.StatusUnauthorized)
+			return
+		}
+
+		// Verify token and role
+		claims, err := auth.VerifyToken(tokenString)
+		if err != nil {
+			http.Error(w, "Invalid token: "+err.Error(), http.StatusUnauthorized)
+			return
+		}
+		if claims.Role != "admin" {
+			http.Error(w, "Admin role required", http.StatusForbidden)
+			return
+		}
+
+		// Pass to next handler
+		next(w, r)
+	}
+}
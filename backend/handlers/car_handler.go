@@ -2,14 +2,22 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
+	"log"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/Samuel-k276/backend/auth"
+	"github.com/Samuel-k276/backend/constants"
 	"github.com/Samuel-k276/backend/database"
 	"github.com/Samuel-k276/backend/models"
+	"github.com/jackc/pgx/v5/pgxpool"
 )
 
+// Cabeçalho com o token de acesso de um carrinho
+const carTokenHeader = "X-Car-Token"
+
 // Estrutura para receber requisições de atualização de carrinhos
 type CarRequest struct {
 	ID string `json:"id"`
@@ -17,15 +25,11 @@ type CarRequest struct {
 
 // Estrutura para receber requisições de criação de carrinhos com senha
 type CreateCarRequest struct {
-	Password string `json:"password"`
-	Type     string `json:"type"`
-}
-
-// Estrutura para receber requisições de adição de produtos ao carrinho
-type AddProductRequest struct {
-	ProductID      string    `json:"product_id"`
-	Quantity       int       `json:"quantity"`
-	ExpirationDate time.Time `json:"expiration_date"`
+	Password      string `json:"password"`
+	Type          string `json:"type"`
+	Subtype       string `json:"subtype"`
+	Warehouse     string `json:"id_warehouse"`
+	WarehouseDest string `json:"id_warehouse_dest"`
 }
 
 // CreateCarHandler cria um novo carrinho
@@ -42,11 +46,47 @@ func CreateCarHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if req.Type != "Entrada" && req.Type != "Saída" {
-		http.Error(w, "Tipo de carrinho inválido. Deve ser 'Entrada' ou 'Saída'", http.StatusBadRequest)
+	if !database.IsValidCarType(req.Type) {
+		http.Error(w, "Tipo de carrinho inválido. Deve ser 'Entrada', 'Saída', 'Inventário' ou 'Transferência'", http.StatusBadRequest)
+		return
+	}
+
+	// Sem armazém indicado o carrinho pertence ao armazém principal
+	if req.Warehouse == "" {
+		req.Warehouse = database.DefaultWarehouse
+	}
+	if _, err := models.GetWarehouse(database.GetDB(), req.Warehouse); err != nil {
+		http.Error(w, "Armazém não encontrado", http.StatusBadRequest)
 		return
 	}
 
+	// Só as transferências têm armazém de destino, que tem de ser outro
+	if req.Type == database.CarTypeTransferencia {
+		if req.WarehouseDest == "" || req.WarehouseDest == req.Warehouse {
+			http.Error(w, "Uma transferência precisa de um armazém de destino diferente do de origem", http.StatusBadRequest)
+			return
+		}
+		if _, err := models.GetWarehouse(database.GetDB(), req.WarehouseDest); err != nil {
+			http.Error(w, "Armazém de destino não encontrado", http.StatusBadRequest)
+			return
+		}
+	} else {
+		req.WarehouseDest = ""
+	}
+
+	// Só os carrinhos de saída têm subtipo, por omissão são doações
+	if req.Type == database.CarTypeSaida {
+		if req.Subtype == "" {
+			req.Subtype = database.SubtypeDonation
+		}
+		if !database.IsValidSubtype(req.Subtype) {
+			http.Error(w, "Subtipo de saída inválido", http.StatusBadRequest)
+			return
+		}
+	} else {
+		req.Subtype = ""
+	}
+
 	// Usar a função do módulo auth para verificar a senha
 	valid := auth.VerifyPasswordDirectly(req.Password)
 	if !valid {
@@ -58,7 +98,7 @@ func CreateCarHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Criar novo carrinho
-	car, err := database.CreateCar(database.GetDB(), req.Type)
+	car, err := database.CreateCar(database.GetDB(), req.Type, req.Subtype, req.Warehouse, req.WarehouseDest)
 
 	if err != nil {
 		http.Error(w, "Erro ao criar carrinho: "+err.Error(), http.StatusInternalServerError)
@@ -70,29 +110,103 @@ func CreateCarHandler(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(car)
 }
 
-// GetCarHandler retorna um carrinho pelo ID
-func GetCarHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
+// NormalizeAllCarsHandler junta as linhas repetidas de todos os carrinhos abertos (só administradores)
+func NormalizeAllCarsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
 		http.Error(w, "Método não permitido", http.StatusMethodNotAllowed)
 		return
 	}
 
-	// Obter ID do carrinho da query string
-	carID := r.URL.Query().Get("id")
-	if carID == "" {
-		http.Error(w, "ID do carrinho é obrigatório", http.StatusBadRequest)
+	merged, err := database.NormalizeOpenCars(database.GetDB())
+	if err != nil {
+		log.Printf("Erro ao juntar as linhas dos carrinhos: %v", err)
+		http.Error(w, "Erro ao juntar as linhas dos carrinhos", http.StatusInternalServerError)
 		return
 	}
 
-	// Procurar carrinho
-	car, err := database.GetCar(database.GetDB(), carID)
-	if err != nil {
-		http.Error(w, "Carrinho não encontrado", http.StatusNotFound)
-		return
+	for carID := range merged {
+		broadcastCartUpdate(database.GetDB(), carID)
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(car)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"merged": merged,
+	})
+}
+
+// Obtém o token de acesso do carrinho do cabeçalho ou, nos browsers que não enviam cabeçalhos, da query string
+func carAccessToken(r *http.Request) string {
+	if token := r.Header.Get(carTokenHeader); token != "" {
+		return token
+	}
+	return r.URL.Query().Get("token")
+}
+
+// Verifica se o pedido pode aceder ao carrinho, com o token do carrinho ou com um token JWT válido
+// Se não puder responde com o erro e devolve false
+func authorizeCarAccess(db *pgxpool.Pool, w http.ResponseWriter, r *http.Request, carID string) bool {
+
+	// Os utilizadores autenticados acedem a todos os carrinhos
+	if _, err := auth.VerifyToken(userToken(r)); err == nil {
+		return true
+	}
+
+	token := carAccessToken(r)
+
+	// O token na query string também pode ser um JWT, como no WebSocket dos administradores
+	if _, err := auth.VerifyToken(token); err == nil {
+		return true
+	}
+
+	allowed, err := database.CheckCarAccess(db, carID, token)
+	if errors.Is(err, database.ErrCarNotFound) {
+		http.Error(w, "Carrinho não encontrado", http.StatusNotFound)
+		return false
+	}
+	if err != nil {
+		http.Error(w, "Erro ao verificar o acesso ao carrinho: "+err.Error(), http.StatusInternalServerError)
+		return false
+	}
+	if !allowed {
+		http.Error(w, "Token de acesso ao carrinho inválido", http.StatusUnauthorized)
+		return false
+	}
+
+	return true
+}
+
+// Responde com o código HTTP que corresponde ao erro da alteração do carrinho
+func writeCarError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, database.ErrCarNotFound):
+		http.Error(w, "Carrinho não encontrado", http.StatusNotFound)
+	case errors.Is(err, database.ErrCarNotOpen):
+		http.Error(w, "O carrinho já não está aberto e não pode ser alterado", http.StatusConflict)
+	case errors.Is(err, database.ErrInvalidTransition), errors.Is(err, database.ErrExportNeedsExporter):
+		http.Error(w, "Mudança de estado inválida: "+err.Error(), http.StatusConflict)
+	case errors.Is(err, database.ErrCarExported):
+		http.Error(w, "O carrinho foi exportado e fica no histórico, não pode ser apagado", http.StatusConflict)
+	case errors.Is(err, database.ErrDonorNotFound):
+		http.Error(w, "Doador não encontrado", http.StatusBadRequest)
+	case errors.Is(err, database.ErrInvalidDocumentDate):
+		http.Error(w, "Data inválida, deve estar no formato AAAA-MM-DD", http.StatusBadRequest)
+	case errors.Is(err, errInvalidLine):
+		http.Error(w, "ID do produto e uma quantidade maior que zero são obrigatórios", http.StatusBadRequest)
+	case errors.Is(err, errStatusNeedsLogin):
+		http.Error(w, "Só utilizadores autenticados (token JWT) podem mudar o estado do carrinho ou apagá-lo", http.StatusUnauthorized)
+	case errors.Is(err, database.ErrInvalidSubtype):
+		http.Error(w, "Subtipo de saída inválido", http.StatusBadRequest)
+	case errors.Is(err, database.ErrCarNotSaida):
+		http.Error(w, "Só os carrinhos de saída têm subtipo", http.StatusConflict)
+	case errors.Is(err, database.ErrInvalidReason):
+		http.Error(w, "Código de motivo (reason) inválido, veja /reports/write-offs/reasons", http.StatusBadRequest)
+	case errors.Is(err, errInvalidExpiration):
+		http.Error(w, "Data de expiração inválida, use AAAA-MM-DD ou ISO 8601", http.StatusBadRequest)
+	case errors.Is(err, database.ErrCarConflict), errors.Is(err, database.ErrLineConflict):
+		http.Error(w, "O carrinho foi alterado por outra pessoa, carregue-o de novo e repita a alteração", http.StatusConflict)
+	default:
+		http.Error(w, "Erro ao alterar o carrinho: "+err.Error(), http.StatusInternalServerError)
+	}
 }
 
 func GetAllCarsHandler(w http.ResponseWriter, r *http.Request) {
@@ -112,150 +226,109 @@ func GetAllCarsHandler(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(carts)
 }
 
-// AddProductToCarHandler adiciona um produto ao carrinho
-func AddProductToCarHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
+// CarHistoryHandler procura nos carrinhos exportados, cancelados e arquivados
+func CarHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
 		http.Error(w, "Método não permitido", http.StatusMethodNotAllowed)
 		return
 	}
 
-	// Obter ID do carrinho da query string
-	carID := r.URL.Query().Get("id")
-	if carID == "" {
-		http.Error(w, "ID do carrinho é obrigatório", http.StatusBadRequest)
+	from, to, ok := getDateRange(w, r)
+	if !ok {
 		return
 	}
 
-	// Buscar carrinho
-	car, exists := models.GlobalCarStore.GetCar(carID)
-	if !exists {
-		http.Error(w, "Carrinho não encontrado", http.StatusNotFound)
-		return
+	query := r.URL.Query()
+	filter := database.CarHistoryFilter{
+		Status:         query.Get("status"),
+		Type:           query.Get("type"),
+		IDWarehouse:    query.Get("warehouse"),
+		From:           from,
+		To:             to,
+		DocumentNumber: query.Get("document"),
+		IDDonor:        query.Get("donor"),
+		IDProduct:      query.Get("product"),
 	}
 
-	var req AddProductRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Erro ao decodificar JSON: "+err.Error(), http.StatusBadRequest)
-		return
+	// Paginação, por omissão são devolvidos os 50 mais recentes
+	for name, value := range map[string]*int{"limit": &filter.Limit, "offset": &filter.Offset} {
+		if param := query.Get(name); param != "" {
+			number, err := strconv.Atoi(param)
+			if err != nil || number < 0 {
+				http.Error(w, "Valor inválido para "+name, http.StatusBadRequest)
+				return
+			}
+			*value = number
+		}
 	}
 
-	if req.ProductID == "" || req.Quantity <= 0 || req.ExpirationDate.IsZero() {
-		http.Error(w, "ID do produto, quantidade e data de expiração são obrigatórios", http.StatusBadRequest)
+	carts, err := database.SearchCarHistory(database.GetDB(), filter)
+	if errors.Is(err, database.ErrInvalidHistoryStatus) {
+		http.Error(w, "Estado inválido, use exported, cancelled ou archived", http.StatusBadRequest)
 		return
 	}
-
-	// Buscar informações do produto
-	db := GetDB()
-	product, err := models.GetProduct(db, req.ProductID)
 	if err != nil {
-		http.Error(w, "Erro ao buscar produto: "+err.Error(), http.StatusInternalServerError)
+		http.Error(w, "Erro ao procurar no histórico", http.StatusInternalServerError)
 		return
 	}
 
-	// Adicionar produto ao carrinho
-	productInCar := models.ProductInCar{
-		ID:             product.ID,
-		Name:           product.Name,
-		Quantity:       req.Quantity,
-		Unit:           product.Unit,
-		ExpirationDate: req.ExpirationDate,
-	}
-
-	car.AddProductToCar(productInCar)
-
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(car)
+	json.NewEncoder(w).Encode(carts)
 }
 
-// RemoveProductFromCarHandler remove um produto do carrinho
-func RemoveProductFromCarHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodDelete {
+// StaleCarsHandler lista os carrinhos abertos sem atividade, antes de serem cancelados (só administradores)
+func StaleCarsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
 		http.Error(w, "Método não permitido", http.StatusMethodNotAllowed)
 		return
 	}
 
-	// Obter ID do carrinho e do produto da query string
-	carID := r.URL.Query().Get("car_id")
-	productID := r.URL.Query().Get("product_id")
-
-	if carID == "" || productID == "" {
-		http.Error(w, "IDs do carrinho e do produto são obrigatórios", http.StatusBadRequest)
-		return
-	}
-
-	// Buscar carrinho
-	car, exists := models.GlobalCarStore.GetCar(carID)
-	if !exists {
-		http.Error(w, "Carrinho não encontrado", http.StatusNotFound)
+	carts, err := database.GetStaleCars(database.GetDB(), constants.GetStaleDays())
+	if err != nil {
+		http.Error(w, "Erro ao procurar carrinhos parados", http.StatusInternalServerError)
 		return
 	}
 
-	// Verificar se foi fornecida uma data de expiração específica
-	expDateStr := r.URL.Query().Get("expiration_date")
-	var expDate time.Time
-	var removeAll bool
-	if expDateStr == "" {
-		// Se não foi fornecida, remover todos os produtos com o mesmo ID
-		removeAll = true
-	} else {
-		// Caso contrário, tentar fazer o parse da data de expiração
-		// Se não conseguir, retornar erro
-		var err error
-		expDate, err = time.Parse(time.RFC3339, expDateStr)
-		if err != nil {
-			http.Error(w, "Data de expiração inválida: "+err.Error(), http.StatusBadRequest)
-			return
-		}
-	}
-
-	// Remover produto do carrinho
-	car.RemoveProductFromCar(productID, expDate, removeAll)
-
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(car)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"stale_days":  constants.GetStaleDays(),
+		"grace_hours": constants.GetStaleGraceHours(),
+		"cars":        carts,
+	})
 }
 
-// UpdateProductQuantityHandler atualiza a quantidade de um produto no carrinho
-func UpdateProductQuantityHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPut {
-		http.Error(w, "Método não permitido", http.StatusMethodNotAllowed)
-		return
-	}
-
-	// Obter ID do carrinho da query string
-	carID := r.URL.Query().Get("car_id")
-	productID := r.URL.Query().Get("product_id")
+// CleanupStaleCars marca os carrinhos parados, avisa quem os tem abertos e cancela os que foram avisados há mais tempo
+func CleanupStaleCars(db *pgxpool.Pool) {
+	graceHours := constants.GetStaleGraceHours()
 
-	if carID == "" || productID == "" {
-		http.Error(w, "IDs do carrinho e do produto são obrigatórios", http.StatusBadRequest)
+	flagged, err := database.FlagStaleCars(db, constants.GetStaleDays())
+	if err != nil {
+		log.Printf("Erro ao marcar carrinhos parados: %v", err)
 		return
 	}
-
-	// Buscar carrinho
-	car, exists := models.GlobalCarStore.GetCar(carID)
-	if !exists {
-		http.Error(w, "Carrinho não encontrado", http.StatusNotFound)
-		return
+	cancelAt := time.Now().Add(time.Duration(graceHours) * time.Hour)
+	for _, idCar := range flagged {
+		broadcastToCar(idCar, map[string]interface{}{
+			"action":    "StaleWarning",
+			"id_car":    idCar,
+			"cancel_at": cancelAt,
+		})
 	}
 
-	var req struct {
-		Quantity       int       `json:"quantity"`
-		ExpirationDate time.Time `json:"expiration_date"`
+	// Mesmo com erro, os que já foram cancelados são avisados
+	cancelled, err := database.CancelStaleCars(db, graceHours)
+	if err != nil {
+		log.Printf("Erro ao cancelar carrinhos parados: %v", err)
 	}
-
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Erro ao decodificar JSON: "+err.Error(), http.StatusBadRequest)
-		return
+	for _, idCar := range cancelled {
+		broadcastCartUpdate(db, idCar)
 	}
 
-	if req.Quantity <= 0 || req.ExpirationDate.IsZero() {
-		http.Error(w, "Quantidade e data de expiração são obrigatórios", http.StatusBadRequest)
-		return
+	if len(flagged) > 0 || len(cancelled) > 0 {
+		broadcastAdmin(map[string]interface{}{
+			"action":    "StaleCars",
+			"flagged":   flagged,
+			"cancelled": cancelled,
+		})
 	}
-
-	// Atualizar quantidade do produto
-	car.ChangeProductQuantity(productID, req.ExpirationDate, req.Quantity)
-
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(car)
 }
@@ -0,0 +1,410 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/Samuel-k276/backend/auth"
+	"github.com/Samuel-k276/backend/database"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Pedido de alteração de um carrinho, só são alterados os campos enviados
+// A versão do carrinho é obrigatória para alterar os dados ou o subtipo
+type CarPatchRequest struct {
+	Version *int                  `json:"version"`
+	Status  *string               `json:"status"`
+	Subtype *string               `json:"subtype"`
+	Info    *database.CarMetadata `json:"info"`
+}
+
+// Pedido de alteração de uma linha, só são alterados os campos enviados
+// A versão da linha que foi editada é obrigatória
+type CarLinePatchRequest struct {
+	Version     *int     `json:"version"`
+	Quantity    *float64 `json:"quantity"`
+	Expiration  *string  `json:"expiration"`
+	Description *string  `json:"description"`
+	Reason      *string  `json:"reason"`
+}
+
+// Pedido de exportação, só um administrador pode exportar sem stock suficiente
+type CarExportRequest struct {
+	Override bool `json:"override"`
+}
+
+// Estados que podem ser pedidos em PATCH, os das ações Lock, Unlock e Cancel e o arquivo de um carrinho exportado
+var patchStatuses = map[string]bool{
+	database.CarStatusOpen:      true,
+	database.CarStatusLocked:    true,
+	database.CarStatusCancelled: true,
+	database.CarStatusArchived:  true,
+}
+
+// RegisterCarResourceHandlers registra a API dos carrinhos e das suas linhas como recursos
+func RegisterCarResourceHandlers(mux *http.ServeMux, db *pgxpool.Pool) {
+	// O caminho será "/cars/ABC123", "/cars/ABC123/lines/42", "/cars/ABC123/export", "/cars/ABC123/report.pdf", ...
+	// as outras rotas de /cars/ são mais específicas
+	mux.HandleFunc("/cars/", func(w http.ResponseWriter, r *http.Request) {
+		parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/cars/"), "/")
+		id := parts[0]
+		if id == "" || len(parts) > 3 {
+			http.Error(w, "Página não encontrada", http.StatusNotFound)
+			return
+		}
+
+		// Só quem tem o token do carrinho, ou um token JWT, pode usar o carrinho
+		if !authorizeCarAccess(db, w, r, id) {
+			return
+		}
+
+		carts := newCarService(db)
+		switch {
+		case len(parts) == 1:
+			handleCar(w, r, db, carts, id)
+		case parts[1] == "lines" && len(parts) == 2:
+			handleCarLines(w, r, db, carts, id)
+		case parts[1] == "lines":
+			lineID, err := strconv.Atoi(parts[2])
+			if err != nil {
+				http.Error(w, "ID da linha inválido", http.StatusBadRequest)
+				return
+			}
+			handleCarLine(w, r, db, carts, id, lineID)
+		case len(parts) == 2:
+			handleCarDocument(w, r, db, carts, id, parts[1])
+		default:
+			http.Error(w, "Página não encontrada", http.StatusNotFound)
+		}
+	})
+}
+
+// GET, PATCH e DELETE de /cars/{id}
+func handleCar(w http.ResponseWriter, r *http.Request, db *pgxpool.Pool, carts *carService, id string) {
+	switch r.Method {
+	case http.MethodGet:
+		writeCar(w, db, id, http.StatusOK)
+
+	case http.MethodPatch:
+		var req CarPatchRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Erro ao decodificar JSON: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if req.Status != nil && !patchStatuses[*req.Status] {
+			http.Error(w, "Estado inválido, use open, locked, cancelled ou archived (para exportar use /cars/{id}/export)", http.StatusBadRequest)
+			return
+		}
+		// Mudar o estado exige o token JWT, como em /cars/status, o token do carrinho não chega
+		if req.Status != nil {
+			if _, err := auth.VerifyToken(userToken(r)); err != nil {
+				writeCarError(w, errStatusNeedsLogin)
+				return
+			}
+		}
+		if (req.Info != nil || req.Subtype != nil) && (req.Version == nil || *req.Version <= 0) {
+			http.Error(w, "A versão do carrinho (version) é obrigatória para alterar os dados ou o subtipo", http.StatusBadRequest)
+			return
+		}
+
+		// Tudo numa transação: primeiro os dados, depois o estado, para poder preencher o formulário e bloquear de uma vez
+		// Sem versão só pode mudar o estado
+		version := 0
+		if req.Version != nil {
+			version = *req.Version
+		}
+		patch := database.CarPatch{Metadata: req.Info, Subtype: req.Subtype, Status: req.Status}
+		if err := carts.Patch(id, version, patch); err != nil {
+			writeCarChangeError(w, db, id, 0, err)
+			return
+		}
+		writeCar(w, db, id, http.StatusOK)
+
+	case http.MethodDelete:
+		// Apagar não pode ser desfeito, o token do carrinho não chega
+		if _, err := auth.VerifyToken(userToken(r)); err != nil {
+			writeCarError(w, errStatusNeedsLogin)
+			return
+		}
+		// Os carrinhos exportados ficam no histórico
+		if err := carts.Delete(id); err != nil {
+			writeCarError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "Método não permitido", http.StatusMethodNotAllowed)
+	}
+}
+
+// GET e POST de /cars/{id}/lines
+func handleCarLines(w http.ResponseWriter, r *http.Request, db *pgxpool.Pool, carts *carService, id string) {
+	switch r.Method {
+	case http.MethodGet:
+		car, err := database.GetCar(db, id)
+		if err != nil {
+			writeCarError(w, carLookupError(err))
+			return
+		}
+		lines := car.Products
+		if lines == nil {
+			lines = []database.Car_Product{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(lines)
+
+	case http.MethodPost:
+		var line CarLineInput
+		if err := json.NewDecoder(r.Body).Decode(&line); err != nil {
+			http.Error(w, "Erro ao decodificar JSON: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		expiration, err := parseExpiration(line.Expiration)
+		if err != nil {
+			writeCarError(w, err)
+			return
+		}
+		line.Expiration = expiration
+
+		pick, err := carts.AddLine(id, line)
+		if err != nil {
+			writeCarError(w, err)
+			return
+		}
+
+		car, err := database.GetCar(db, id)
+		if err != nil {
+			http.Error(w, "Erro ao procurar carrinho: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		// Nas saídas vão também os lotes a retirar primeiro
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(struct {
+			*database.Car
+			Pick *FEFOPick `json:"pick,omitempty"`
+		}{car, pick})
+
+	default:
+		http.Error(w, "Método não permitido", http.StatusMethodNotAllowed)
+	}
+}
+
+// GET, PATCH e DELETE de /cars/{id}/lines/{lineId}
+func handleCarLine(w http.ResponseWriter, r *http.Request, db *pgxpool.Pool, carts *carService, id string, lineID int) {
+	car, err := database.GetCar(db, id)
+	if err != nil {
+		writeCarError(w, carLookupError(err))
+		return
+	}
+
+	var line *database.Car_Product
+	for i := range car.Products {
+		if car.Products[i].ID == lineID {
+			line = &car.Products[i]
+			break
+		}
+	}
+	if line == nil {
+		http.Error(w, "Linha não encontrada no carrinho", http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(line)
+
+	case http.MethodPatch:
+		var req CarLinePatchRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Erro ao decodificar JSON: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if req.Version == nil {
+			http.Error(w, "A versão da linha (version) é obrigatória", http.StatusBadRequest)
+			return
+		}
+
+		edit := CarLineInput{
+			IDProduct:   line.IDProduct,
+			Quantity:    line.Quantity,
+			Expiration:  line.Expiration,
+			Description: line.Description,
+			Reason:      line.Reason,
+		}
+		if req.Quantity != nil {
+			edit.Quantity = *req.Quantity
+		}
+		if req.Expiration != nil {
+			if edit.Expiration, err = parseExpiration(*req.Expiration); err != nil {
+				writeCarError(w, err)
+				return
+			}
+		}
+		if req.Description != nil {
+			edit.Description = *req.Description
+		}
+		if req.Reason != nil {
+			edit.Reason = *req.Reason
+		}
+		if edit.Quantity <= 0 {
+			writeCarError(w, errInvalidLine)
+			return
+		}
+
+		if err := carts.EditLine(id, lineID, *req.Version, edit); err != nil {
+			writeCarChangeError(w, db, id, lineID, err)
+			return
+		}
+		writeCar(w, db, id, http.StatusOK)
+
+	case http.MethodDelete:
+		version, err := strconv.Atoi(r.URL.Query().Get("version"))
+		if err != nil {
+			http.Error(w, "A versão da linha (version) é obrigatória", http.StatusBadRequest)
+			return
+		}
+		if err := carts.RemoveLine(id, lineID, version); err != nil {
+			writeCarChangeError(w, db, id, lineID, err)
+			return
+		}
+		writeCar(w, db, id, http.StatusOK)
+
+	default:
+		http.Error(w, "Método não permitido", http.StatusMethodNotAllowed)
+	}
+}
+
+// Exportação e documentos de /cars/{id}/...
+func handleCarDocument(w http.ResponseWriter, r *http.Request, db *pgxpool.Pool, carts *carService, id string, name string) {
+	if name == "export" {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Método não permitido", http.StatusMethodNotAllowed)
+			return
+		}
+		exportCarHandler(w, r, db, carts, id)
+		return
+	}
+	if name == "normalize" {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Método não permitido", http.StatusMethodNotAllowed)
+			return
+		}
+		normalizeCarHandler(w, db, carts, id)
+		return
+	}
+
+	sheetName, format, isSheet := splitSheetName(name)
+	if name != "report.pdf" && !(isSheet && sheetName == "export") {
+		http.Error(w, "Página não encontrada", http.StatusNotFound)
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "Método não permitido", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if isSheet {
+		exportCar(w, db, id, format)
+	} else {
+		getCarReportPDF(w, db, id)
+	}
+}
+
+// Exporta o carrinho, como a ação Export do WebSocket
+// O token JWT de administrador para exportar sem stock vai no cabeçalho Authorization
+func exportCarHandler(w http.ResponseWriter, r *http.Request, db *pgxpool.Pool, carts *carService, id string) {
+	var req CarExportRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Erro ao decodificar JSON: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	err := carts.Export(id, req.Override, auth.ExtractTokenFromRequest(r))
+	if err != nil {
+		log.Println("Error exporting the car:", err)
+		writeExportError(w, err)
+		return
+	}
+
+	writeCar(w, db, id, http.StatusOK)
+}
+
+// Junta as linhas repetidas do carrinho (mesmo produto, validade e motivo), só num carrinho aberto
+func normalizeCarHandler(w http.ResponseWriter, db *pgxpool.Pool, carts *carService, id string) {
+	removed, err := carts.Normalize(id)
+	if err != nil {
+		writeCarError(w, err)
+		return
+	}
+
+	car, err := database.GetCar(db, id)
+	if err != nil {
+		writeCarError(w, carLookupError(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"merged": removed,
+		"car":    car,
+	})
+}
+
+// Responde ao erro da exportação, com as linhas ou os produtos que têm de ser corrigidos
+func writeExportError(w http.ResponseWriter, err error) {
+	var missing *database.MissingReasonError
+	var shortage *database.StockShortageError
+
+	response := map[string]interface{}{"error": err.Error()}
+	switch {
+	case errors.Is(err, errOverrideNotAllowed):
+		http.Error(w, "Só um administrador pode exportar sem stock suficiente", http.StatusForbidden)
+		return
+	case errors.As(err, &missing):
+		response["lines"] = missing.Lines
+	case errors.As(err, &shortage):
+		response["shortages"] = shortage.Shortages
+	default:
+		writeCarError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusConflict)
+	json.NewEncoder(w).Encode(response)
+}
+
+// Responde ao erro de uma alteração, num conflito de versões com o estado atual do carrinho ou da linha
+func writeCarChangeError(w http.ResponseWriter, db *pgxpool.Pool, id string, lineID int, err error) {
+	if !isConflict(err) {
+		writeCarError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusConflict)
+	json.NewEncoder(w).Encode(conflictState(db, id, lineID, err))
+}
+
+// Responde com o carrinho como está agora
+func writeCar(w http.ResponseWriter, db *pgxpool.Pool, id string, status int) {
+	car, err := database.GetCar(db, id)
+	if err != nil {
+		writeCarError(w, carLookupError(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(car)
+}
@@ -0,0 +1,337 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/Samuel-k276/backend/auth"
+	"github.com/Samuel-k276/backend/database"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Erros das alterações pedidas aos carrinhos
+var (
+	errInvalidLine       = errors.New("the product and a quantity above zero are needed")
+	errInvalidExpiration = errors.New("the expiration must be YYYY-MM-DD or RFC 3339")
+)
+
+// Linha de produto pedida por REST ou pelo WebSocket
+type CarLineInput struct {
+	IDProduct   string  `json:"id_product"`
+	Quantity    float64 `json:"quantity"`
+	Expiration  string  `json:"expiration"`
+	Description string  `json:"description"`
+	Reason      string  `json:"reason"`
+}
+
+// Lotes a retirar primeiro quando um produto é adicionado a uma saída
+// Os lotes expirados nunca são sugeridos, vão à parte para serem retirados da prateleira
+// O que o stock não cobre fica em Missing e é adicionado numa linha sem data, para não se perder
+type FEFOPick struct {
+	IDProduct  string                    `json:"id_product"`
+	Quantity   float64                   `json:"quantity"`
+	Lots       []database.PickSuggestion `json:"lots"`
+	Expired    []database.PickSuggestion `json:"expired"`
+	Missing    float64                   `json:"missing"`
+	AutoFilled bool                      `json:"auto_filled"`
+}
+
+// Serviço dos carrinhos: as alterações feitas por REST e pelo WebSocket passam todas por aqui,
+// para terem as mesmas regras e avisarem da mesma forma os utilizadores ligados ao carrinho
+type carService struct {
+	db *pgxpool.Pool
+}
+
+// Cria o serviço dos carrinhos sobre a base de dados
+func newCarService(db *pgxpool.Pool) *carService {
+	return &carService{db: db}
+}
+
+// Adiciona uma linha ao carrinho, nas saídas devolve os lotes a retirar primeiro (FEFO)
+func (s *carService) AddLine(idCar string, line CarLineInput) (*FEFOPick, error) {
+	if line.IDProduct == "" || line.Quantity <= 0 {
+		return nil, errInvalidLine
+	}
+
+	carType, err := database.GetCarType(s.db, idCar)
+	if err != nil {
+		return nil, carLookupError(err)
+	}
+
+	var pick *FEFOPick
+	if carType == database.CarTypeSaida {
+		pick, err = s.addLineFEFO(idCar, line)
+	} else {
+		_, err = database.AddProductCar(s.db, idCar, line.IDProduct, line.Quantity, line.Expiration, line.Description, line.Reason)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	broadcastCartUpdate(s.db, idCar)
+	return pick, nil
+}
+
+// Adiciona o produto a uma saída seguindo os lotes que expiram primeiro
+// Sem data de validade as linhas são preenchidas com os lotes sugeridos, todas na mesma transação
+// O que o stock não cobre vai numa linha sem data, a exportação só a aceita se houver stock sem data
+func (s *carService) addLineFEFO(idCar string, line CarLineInput) (*FEFOPick, error) {
+
+	suggestions, expired, missing, err := database.SuggestFEFO(s.db, idCar, line.IDProduct, line.Quantity)
+	if err != nil {
+		return nil, err
+	}
+
+	autoFilled := line.Expiration == ""
+	lines := []database.Car_Product{}
+	if autoFilled {
+		for _, suggestion := range suggestions {
+			lines = append(lines, database.Car_Product{
+				IDProduct:   line.IDProduct,
+				Quantity:    suggestion.Quantity,
+				Expiration:  suggestion.Expiration,
+				Description: line.Description,
+				Reason:      line.Reason,
+			})
+		}
+
+		// A quantidade pedida fica toda no carrinho, o que os lotes não cobrem fica sem lote
+		if missing > 0 {
+			lines = append(lines, database.Car_Product{
+				IDProduct:   line.IDProduct,
+				Quantity:    missing,
+				Description: line.Description,
+				Reason:      line.Reason,
+			})
+		}
+	} else {
+		lines = append(lines, database.Car_Product{
+			IDProduct:   line.IDProduct,
+			Quantity:    line.Quantity,
+			Expiration:  line.Expiration,
+			Description: line.Description,
+			Reason:      line.Reason,
+		})
+	}
+
+	if err := database.AddProductCarLines(s.db, idCar, lines); err != nil {
+		return nil, err
+	}
+
+	return &FEFOPick{
+		IDProduct:  line.IDProduct,
+		Quantity:   line.Quantity,
+		Lots:       suggestions,
+		Expired:    expired,
+		Missing:    missing,
+		AutoFilled: autoFilled,
+	}, nil
+}
+
+// Altera uma linha do carrinho, só se ainda estiver na versão que foi editada
+func (s *carService) EditLine(idCar string, id int, version int, line CarLineInput) error {
+	if err := database.EditProductCar(s.db, idCar, id, version, line.Quantity, line.Expiration, line.Description, line.Reason); err != nil {
+		return err
+	}
+
+	broadcastCartUpdate(s.db, idCar)
+	return nil
+}
+
+// Remove uma linha do carrinho, só se ainda estiver na versão que foi vista
+func (s *carService) RemoveLine(idCar string, id int, version int) error {
+	if err := database.DeleteProductCar(s.db, idCar, id, version); err != nil {
+		return err
+	}
+
+	broadcastCartUpdate(s.db, idCar)
+	return nil
+}
+
+// Junta as linhas repetidas do carrinho, devolve quantas linhas foram removidas
+func (s *carService) Normalize(idCar string) (int, error) {
+	removed, err := database.NormalizeCar(s.db, idCar)
+	if err != nil {
+		return 0, err
+	}
+
+	if removed > 0 {
+		broadcastCartUpdate(s.db, idCar)
+	}
+	return removed, nil
+}
+
+// Altera os dados do formulário de exportação, só se o carrinho ainda estiver na versão que foi editada
+func (s *carService) UpdateInfo(idCar string, version int, metadata database.CarMetadata) error {
+	if err := database.UpdateCarMetadata(s.db, idCar, version, metadata); err != nil {
+		return err
+	}
+
+	broadcastCartUpdate(s.db, idCar)
+	return nil
+}
+
+// Altera o subtipo de uma saída, só se o carrinho ainda estiver na versão que foi editada
+func (s *carService) SetSubtype(idCar string, version int, subtype string) error {
+	if err := database.SetCarSubtype(s.db, idCar, version, subtype); err != nil {
+		return err
+	}
+
+	broadcastCartUpdate(s.db, idCar)
+	return nil
+}
+
+// Altera os dados, o subtipo e o estado do carrinho de uma vez, sobre a versão que foi editada
+func (s *carService) Patch(idCar string, version int, patch database.CarPatch) error {
+	if err := database.PatchCar(s.db, idCar, version, patch); err != nil {
+		return err
+	}
+
+	broadcastCartUpdate(s.db, idCar)
+	return nil
+}
+
+// Muda o estado do carrinho, a exportação é feita por Export
+func (s *carService) SetStatus(idCar string, status string) error {
+	if err := database.SetCarStatus(s.db, idCar, status); err != nil {
+		return err
+	}
+
+	broadcastCartUpdate(s.db, idCar)
+	return nil
+}
+
+// Regista atividade no carrinho, para não ser cancelado por estar parado
+func (s *carService) KeepOpen(idCar string) error {
+	if err := database.TouchCar(s.db, idCar); err != nil {
+		return err
+	}
+
+	broadcastCartUpdate(s.db, idCar)
+	return nil
+}
+
+// Apaga um carrinho que nunca foi exportado, quem está ligado a ele é avisado
+func (s *carService) Delete(idCar string) error {
+	if err := database.DeleteCarId(s.db, idCar); err != nil {
+		return err
+	}
+
+	broadcastCarDeleted(idCar)
+	return nil
+}
+
+// Exporta o carrinho, só um administrador (token JWT) pode exportar uma saída sem stock suficiente
+// Depois da exportação avisa das necessidades e dos inventários por aprovar
+func (s *carService) Export(idCar string, override bool, token string) error {
+	if override {
+		claims, err := auth.VerifyToken(token)
+		if err != nil || claims.Role != "admin" {
+			return errOverrideNotAllowed
+		}
+	}
+
+	if err := database.ChangeDateCar(s.db, idCar, override); err != nil {
+		return err
+	}
+
+	// Todos os utilizadores do carrinho veem que foi exportado
+	broadcastCartUpdate(s.db, idCar)
+
+	carType, err := database.GetCarType(s.db, idCar)
+	if err != nil {
+		log.Println("Error handling the function to get the type of the car:", err)
+		return nil
+	}
+
+	// Uma saída pode deixar produtos abaixo do mínimo
+	if carType == database.CarTypeSaida {
+		needs, err := database.GetExportNeeds(s.db, idCar)
+		if err != nil {
+			log.Println("Error retrieving the needs of the car:", err)
+			return nil
+		}
+		if len(needs) > 0 {
+			alert := map[string]interface{}{
+				"action": "NeedsAlert",
+				"id_car": idCar,
+				"needs":  needs,
+			}
+			broadcastToCar(idCar, alert)
+			broadcastAdmin(alert)
+		}
+	}
+
+	// Os administradores têm de aprovar as diferenças de um inventário
+	if carType == database.CarTypeInventario {
+		report, err := database.GetInventoryReport(s.db, idCar)
+		if err != nil {
+			log.Println("Error retrieving the inventory report:", err)
+			return nil
+		}
+		broadcastAdmin(map[string]interface{}{
+			"action": "InventoryVariance",
+			"report": report,
+		})
+	}
+
+	return nil
+}
+
+// Estado atual do que foi alterado por outra pessoa, para o cliente repetir a alteração sobre ele
+// Num conflito de uma linha vai a linha como está agora (null se foi removida), senão os campos do carrinho
+func conflictState(db *pgxpool.Pool, idCar string, lineID int, err error) map[string]interface{} {
+	response := map[string]interface{}{"error": err.Error()}
+
+	car, lookupErr := database.GetCar(db, idCar)
+	if lookupErr != nil {
+		log.Println("Error retrieving the car of the conflict:", lookupErr)
+		return response
+	}
+
+	if errors.Is(err, database.ErrLineConflict) {
+		response["id"] = lineID
+		response["line"] = nil
+		for _, line := range car.Products {
+			if line.ID == lineID {
+				response["line"] = line
+				break
+			}
+		}
+		return response
+	}
+
+	response["car"] = carHeader(car)
+	return response
+}
+
+// Diz se o erro é de uma alteração feita sobre uma versão que já não é a atual
+func isConflict(err error) bool {
+	return errors.Is(err, database.ErrCarConflict) || errors.Is(err, database.ErrLineConflict)
+}
+
+// Um carrinho que não foi encontrado dá ErrCarNotFound, os outros erros ficam como estão
+func carLookupError(err error) error {
+	if errors.Is(err, pgx.ErrNoRows) {
+		return database.ErrCarNotFound
+	}
+	return err
+}
+
+// Lê uma data de validade em YYYY-MM-DD ou RFC 3339 e devolve-a como é guardada, vazia fica vazia
+func parseExpiration(value string) (string, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return "", nil
+	}
+	if date, err := time.Parse(database.ExpirationLayout, value); err == nil {
+		return date.Format(database.ExpirationLayout), nil
+	}
+	if date, err := time.Parse(time.RFC3339, value); err == nil {
+		return date.Format(database.ExpirationLayout), nil
+	}
+	return "", errInvalidExpiration
+}
@@ -0,0 +1,32 @@
+package handlers
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseExpiration(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    string
+		wantErr error
+	}{
+		{"", "", nil},
+		{"   ", "", nil},
+		{"2026-05-10", "2026-05-10", nil},
+		{" 2026-05-10 ", "2026-05-10", nil},
+		{"2026-05-10T00:00:00Z", "2026-05-10", nil},
+		{"2026-05-10T23:30:00-02:00", "2026-05-10", nil},
+		{"2026-02-30", "", errInvalidExpiration},
+		{"10/05/2026", "", errInvalidExpiration},
+		{"2026-5-1", "", errInvalidExpiration},
+		{"amanhã", "", errInvalidExpiration},
+	}
+
+	for _, test := range tests {
+		got, err := parseExpiration(test.value)
+		if got != test.want || !errors.Is(err, test.wantErr) {
+			t.Errorf("parseExpiration(%q) = %q, %v, want %q, %v", test.value, got, err, test.want, test.wantErr)
+		}
+	}
+}
@@ -0,0 +1,300 @@
+package handlers
+
+import (
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Samuel-k276/backend/database"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Most diffs kept by car for the clients that fall behind, older than that they get the whole car
+const maxCarDiffs = 100
+
+// Last sequence number given to an update of a car
+// It is shared by all the cars, so a car that is loaded again never reuses a number a client has seen
+var lastCarSeq atomic.Int64
+
+// The car as its clients have it, with the last diffs sent to them
+type carSync struct {
+	mu    sync.Mutex
+	seq   int64
+	car   *database.Car
+	diffs []carDiff
+}
+
+// Diff sent to the clients and the seq it applies on
+type carDiff struct {
+	baseSeq int64
+	message map[string]interface{}
+}
+
+// State of the cars that have connections, it is dropped when the last one leaves
+var (
+	carSyncs   = make(map[string]*carSync)
+	carSyncsMu sync.Mutex
+)
+
+// Gets the state of the car, creating it empty
+func getCarSync(id_car string) *carSync {
+	carSyncsMu.Lock()
+	defer carSyncsMu.Unlock()
+
+	state, ok := carSyncs[id_car]
+	if !ok {
+		state = &carSync{}
+		carSyncs[id_car] = state
+	}
+	return state
+}
+
+// Forgets the state of a car without connections
+func dropCarSync(id_car string) {
+	carSyncsMu.Lock()
+	defer carSyncsMu.Unlock()
+
+	delete(carSyncs, id_car)
+}
+
+// Gets the state of a car with connections, nil without them
+// The connections are checked with the lock the last one to leave takes to drop the state,
+// so the state is never created again for a car nobody is using
+func connectedCarSync(id_car string) *carSync {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if len(cartClients[id_car]) == 0 {
+		return nil
+	}
+	return getCarSync(id_car)
+}
+
+// Function that tells the users of the car what changed, only the lines added, changed and removed
+// The diff has the seq of the update and the base_seq it applies on, a client that does not have
+// the base_seq asks for the missing updates with the Sync action
+func broadcastCartUpdate(db *pgxpool.Pool, id_car string) {
+
+	// Without connections there is nobody to tell
+	state := connectedCarSync(id_car)
+	if state == nil {
+		return
+	}
+
+	// The car is read with the lock, so the updates are sent in the order they were read
+	state.mu.Lock()
+	defer state.mu.Unlock()
+
+	if err := refreshCarSync(db, state, id_car); err != nil {
+		log.Println("Error retrieving cart from database:", err)
+	}
+}
+
+// Reads the car again and sends to its users what changed since the last update, the state must be locked
+// The changes that are saved without an update (the activity, an approval) go with the next one
+func refreshCarSync(db *pgxpool.Pool, state *carSync, id_car string) error {
+
+	cart, err := database.GetCar(db, id_car)
+	if err != nil {
+		return carLookupError(err)
+	}
+
+	// The first time everybody gets the whole car
+	if state.car == nil {
+		state.car = cart
+		state.seq = lastCarSeq.Add(1)
+		broadcastToCar(id_car, carSnapshot(cart, state.seq))
+		return nil
+	}
+
+	diff := diffCar(state.car, cart)
+	if diff == nil {
+		return nil
+	}
+
+	base := state.seq
+	state.seq = lastCarSeq.Add(1)
+	state.car = cart
+	diff["base_seq"] = base
+	diff["seq"] = state.seq
+
+	state.diffs = append(state.diffs, carDiff{baseSeq: base, message: diff})
+	if len(state.diffs) > maxCarDiffs {
+		state.diffs = state.diffs[len(state.diffs)-maxCarDiffs:]
+	}
+
+	broadcastToCar(id_car, diff)
+	return nil
+}
+
+// Function that tells the users of the car that it was deleted, the state of the car is forgotten
+// so it is never sent again to anyone
+func broadcastCarDeleted(id_car string) {
+
+	mu.Lock()
+	dropCarSync(id_car)
+	mu.Unlock()
+
+	broadcastToCar(id_car, map[string]interface{}{
+		"action": "CarDeleted",
+		"id_car": id_car,
+	})
+}
+
+// Function that adds a new connection to the users of the car
+// The connection is registered with the state of the car, so the state is not dropped meanwhile, but it
+// only gets the updates after it got the whole car. The state is locked in between, so no update is lost
+func joinCar(db *pgxpool.Pool, client *wsClient, id_car string) error {
+
+	mu.Lock()
+	cartClients[id_car] = append(cartClients[id_car], client)
+	state := getCarSync(id_car)
+	mu.Unlock()
+
+	state.mu.Lock()
+	defer state.mu.Unlock()
+
+	err := refreshCarSync(db, state, id_car)
+	if err == nil {
+		sendToClient(client, carSnapshot(state.car, state.seq))
+	}
+
+	// Even without the car the connection gets the updates, the next one has the whole car
+	mu.Lock()
+	client.synced = true
+	mu.Unlock()
+
+	return err
+}
+
+// Function that sends the whole car to one connection, read again from the database
+// What changed without an update is sent first to everybody, so all the users stay on the same seq
+func sendCarSnapshot(db *pgxpool.Pool, client *wsClient, id_car string) error {
+
+	state := connectedCarSync(id_car)
+	if state == nil {
+		return nil
+	}
+
+	state.mu.Lock()
+	defer state.mu.Unlock()
+
+	if err := refreshCarSync(db, state, id_car); err != nil {
+		return err
+	}
+
+	sendToClient(client, carSnapshot(state.car, state.seq))
+	return nil
+}
+
+// Function that sends to one connection the updates after seq
+// When they are not kept anymore, or the seq is not known, the connection gets the whole car
+func syncCar(db *pgxpool.Pool, client *wsClient, id_car string, seq int64) error {
+
+	state := connectedCarSync(id_car)
+	if state == nil {
+		return nil
+	}
+
+	state.mu.Lock()
+
+	if state.car != nil && seq == state.seq {
+		state.mu.Unlock()
+		return nil
+	}
+
+	for i, diff := range state.diffs {
+		if diff.baseSeq == seq {
+			for _, missing := range state.diffs[i:] {
+				sendToClient(client, missing.message)
+			}
+			state.mu.Unlock()
+			return nil
+		}
+	}
+
+	state.mu.Unlock()
+	return sendCarSnapshot(db, client, id_car)
+}
+
+// Message with the whole car
+func carSnapshot(cart *database.Car, seq int64) map[string]interface{} {
+	products := cart.Products
+	if products == nil {
+		products = []database.Car_Product{}
+	}
+
+	message := carHeader(cart)
+	message["action"] = "UpdateCar"
+	message["seq"] = seq
+	message["products"] = products
+	return message
+}
+
+// Fields of the car sent in every update, they are small so they always go whole
+func carHeader(cart *database.Car) map[string]interface{} {
+	return map[string]interface{}{
+		"id_car":          cart.ID,
+		"version":         cart.Version,
+		"status":          cart.Status,
+		"subtype":         cart.Subtype,
+		"document_number": cart.DocumentNumber,
+		"stale_at":        cart.StaleAt,
+		"info":            cart.CarMetadata,
+	}
+}
+
+// Message with what changed from before to after, nil when nothing changed
+func diffCar(before *database.Car, after *database.Car) map[string]interface{} {
+
+	old := make(map[int]database.Car_Product, len(before.Products))
+	for _, line := range before.Products {
+		old[line.ID] = line
+	}
+
+	added := []database.Car_Product{}
+	updated := []database.Car_Product{}
+	for _, line := range after.Products {
+		previous, ok := old[line.ID]
+		if !ok {
+			added = append(added, line)
+		} else if previous != line {
+			updated = append(updated, line)
+		}
+		delete(old, line.ID)
+	}
+
+	// What is left was removed
+	removed := []int{}
+	for _, line := range before.Products {
+		if _, ok := old[line.ID]; ok {
+			removed = append(removed, line.ID)
+		}
+	}
+
+	sameHeader := before.Version == after.Version &&
+		before.Status == after.Status &&
+		before.Subtype == after.Subtype &&
+		before.DocumentNumber == after.DocumentNumber &&
+		sameTime(before.StaleAt, after.StaleAt) &&
+		before.CarMetadata == after.CarMetadata
+	if sameHeader && len(added) == 0 && len(updated) == 0 && len(removed) == 0 {
+		return nil
+	}
+
+	message := carHeader(after)
+	message["action"] = "CarDiff"
+	message["added"] = added
+	message["updated"] = updated
+	message["removed"] = removed
+	return message
+}
+
+// Compares two times that may be missing
+func sameTime(a *time.Time, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Equal(*b)
+}
@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/Samuel-k276/backend/database"
+)
+
+func TestDiffCar(t *testing.T) {
+	line := func(id int, quantity float64, version int) database.Car_Product {
+		return database.Car_Product{ID: id, IDCar: "abc123", IDProduct: "10", Quantity: quantity, Version: version}
+	}
+	car := func(version int, lines ...database.Car_Product) *database.Car {
+		return &database.Car{ID: "abc123", Status: database.CarStatusOpen, Version: version, Products: lines}
+	}
+
+	now := time.Date(2026, 5, 10, 12, 0, 0, 0, time.UTC)
+	sameNow := now.In(time.FixedZone("WEST", 3600))
+	later := now.Add(time.Hour)
+	withStale := func(stale *time.Time) *database.Car {
+		c := car(1, line(1, 2, 1))
+		c.StaleAt = stale
+		return c
+	}
+	withActivity := car(1, line(1, 2, 1))
+	withActivity.LastActivityAt = &later
+	withDonor := car(2, line(1, 2, 1))
+	withDonor.IDDonor = "D001"
+
+	tests := []struct {
+		name        string
+		before      *database.Car
+		after       *database.Car
+		wantNil     bool
+		wantAdded   []int
+		wantUpdated []int
+		wantRemoved []int
+	}{
+		{"nothing changed", car(1, line(1, 2, 1), line(2, 1, 1)), car(1, line(1, 2, 1), line(2, 1, 1)), true, nil, nil, nil},
+		{"only the activity changed", car(1, line(1, 2, 1)), withActivity, true, nil, nil, nil},
+		{"same stale time in another zone", withStale(&now), withStale(&sameNow), true, nil, nil, nil},
+		{"line added", car(1, line(1, 2, 1)), car(1, line(1, 2, 1), line(2, 1, 1)), false, []int{2}, nil, nil},
+		{"line updated", car(1, line(1, 2, 1)), car(1, line(1, 5, 2)), false, nil, []int{1}, nil},
+		{"line removed", car(1, line(1, 2, 1), line(2, 1, 1)), car(1, line(2, 1, 1)), false, nil, nil, []int{1}},
+		{"lines merged", car(1, line(1, 2, 1), line(2, 3, 1)), car(1, line(1, 5, 2)), false, nil, []int{1}, []int{2}},
+		{"first line of an empty car", car(1), car(1, line(7, 1, 1)), false, []int{7}, nil, nil},
+		{"all lines removed", car(1, line(1, 2, 1), line(2, 1, 1)), car(1), false, nil, nil, []int{1, 2}},
+		{"only the header changed", car(1, line(1, 2, 1)), withDonor, false, nil, nil, nil},
+		{"stale time set", withStale(nil), withStale(&now), false, nil, nil, nil},
+		{"stale time moved", withStale(&now), withStale(&later), false, nil, nil, nil},
+	}
+
+	for _, test := range tests {
+		diff := diffCar(test.before, test.after)
+		if test.wantNil {
+			if diff != nil {
+				t.Errorf("%s: got a diff %v, want none", test.name, diff)
+			}
+			continue
+		}
+		if diff == nil {
+			t.Errorf("%s: got no diff", test.name)
+			continue
+		}
+
+		if diff["action"] != "CarDiff" || diff["id_car"] != "abc123" || diff["version"] != test.after.Version {
+			t.Errorf("%s: header of the diff is %v", test.name, diff)
+		}
+		if got := lineIDs(diff["added"].([]database.Car_Product)); fmt.Sprint(got) != fmt.Sprint(test.wantAdded) {
+			t.Errorf("%s: added %v, want %v", test.name, got, test.wantAdded)
+		}
+		if got := lineIDs(diff["updated"].([]database.Car_Product)); fmt.Sprint(got) != fmt.Sprint(test.wantUpdated) {
+			t.Errorf("%s: updated %v, want %v", test.name, got, test.wantUpdated)
+		}
+		if got := diff["removed"].([]int); fmt.Sprint(got) != fmt.Sprint(test.wantRemoved) {
+			t.Errorf("%s: removed %v, want %v", test.name, got, test.wantRemoved)
+		}
+	}
+}
+
+// Ids of the lines, in their order
+func lineIDs(lines []database.Car_Product) []int {
+	ids := []int{}
+	for _, line := range lines {
+		ids = append(ids, line.ID)
+	}
+	return ids
+}
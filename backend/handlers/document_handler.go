@@ -0,0 +1,65 @@
+package handlers
+
+import (
+	"image"
+	"image/png"
+	"log"
+	"net/http"
+	"os"
+
+	"github.com/Samuel-k276/backend/constants"
+	"github.com/Samuel-k276/backend/database"
+	"github.com/Samuel-k276/backend/documents"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Devolve o relatório em PDF de um carrinho exportado
+func getCarReportPDF(w http.ResponseWriter, db *pgxpool.Pool, id string) {
+	car, err := database.GetCar(db, id)
+	if err != nil {
+		http.Error(w, "Carrinho não encontrado", http.StatusNotFound)
+		return
+	}
+
+	// O relatório é o do momento da exportação
+	if car.ExportedAt == nil {
+		http.Error(w, "O carrinho ainda não foi exportado", http.StatusConflict)
+		return
+	}
+
+	// Os nomes dos armazéns são os guardados na exportação
+	info := documents.CarReportInfo{
+		Warehouse:     car.WarehouseName,
+		WarehouseDest: car.WarehouseDestName,
+		Logo:          loadLogo(),
+		Location:      constants.GetLocation(),
+	}
+
+	pdf, err := documents.CarReportPDF(car, info)
+	if err != nil {
+		log.Printf("Erro ao gerar o relatório do carrinho: %v", err)
+		http.Error(w, "Erro ao gerar o relatório", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", `inline; filename="relatorio_`+car.ID+`.pdf"`)
+	w.Write(pdf)
+}
+
+// Lê o logótipo dos relatórios, sem ele os relatórios são feitos sem imagem
+func loadLogo() image.Image {
+	file, err := os.Open(constants.LOGO_PATH)
+	if err != nil {
+		log.Printf("Logótipo não encontrado: %v", err)
+		return nil
+	}
+	defer file.Close()
+
+	logo, err := png.Decode(file)
+	if err != nil {
+		log.Printf("Erro ao ler o logótipo: %v", err)
+		return nil
+	}
+	return logo
+}
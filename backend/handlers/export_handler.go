@@ -0,0 +1,153 @@
+package handlers
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/Samuel-k276/backend/constants"
+	"github.com/Samuel-k276/backend/database"
+	"github.com/Samuel-k276/backend/documents"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Tipos de ficheiro das folhas de cálculo
+var sheetContentTypes = map[string]string{
+	"csv":  "text/csv; charset=utf-8",
+	"xlsx": "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+}
+
+// RegisterExportHandlers registra os handlers das exportações para folhas de cálculo
+func RegisterExportHandlers(mux *http.ServeMux, db *pgxpool.Pool) {
+	// O caminho será "/exports/cars.xlsx", "/exports/stock.csv", ... - com autenticação
+	mux.HandleFunc("/exports/", AuthMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Método não permitido", http.StatusMethodNotAllowed)
+			return
+		}
+
+		name, format, ok := splitSheetName(strings.TrimPrefix(r.URL.Path, "/exports/"))
+		if !ok {
+			http.Error(w, "Página não encontrada", http.StatusNotFound)
+			return
+		}
+
+		switch name {
+		case "cars":
+			exportCars(w, r, db, format)
+		case "stock":
+			exportStock(w, r, db, format)
+		case "movements":
+			exportMovements(w, r, db, format)
+		default:
+			http.Error(w, "Página não encontrada", http.StatusNotFound)
+		}
+	}))
+}
+
+// Separa "stock.csv" em "stock" e "csv", só aceita os formatos conhecidos
+func splitSheetName(file string) (string, string, bool) {
+	format := strings.TrimPrefix(path.Ext(file), ".")
+	if _, ok := sheetContentTypes[format]; !ok || strings.Contains(file, "/") {
+		return "", "", false
+	}
+	return strings.TrimSuffix(file, "."+format), format, true
+}
+
+// Exporta os produtos de um carrinho
+func exportCar(w http.ResponseWriter, db *pgxpool.Pool, id string, format string) {
+	car, err := database.GetCar(db, id)
+	if err != nil {
+		http.Error(w, "Carrinho não encontrado", http.StatusNotFound)
+		return
+	}
+
+	writeSheet(w, documents.CarSheet(car), format, "carrinho_"+car.ID)
+}
+
+// Exporta as linhas dos carrinhos exportados num intervalo de datas
+func exportCars(w http.ResponseWriter, r *http.Request, db *pgxpool.Pool, format string) {
+	from, to, ok := getDateRange(w, r)
+	if !ok {
+		return
+	}
+
+	// Filtros opcionais por tipo de carrinho e armazém
+	carType := r.URL.Query().Get("type")
+	if carType != "" && !database.IsValidCarType(carType) {
+		http.Error(w, "Tipo de carrinho inválido", http.StatusBadRequest)
+		return
+	}
+
+	lines, err := database.GetExportedCarLines(db, from, to, carType, r.URL.Query().Get("warehouse"))
+	if err != nil {
+		log.Printf("Erro ao procurar carrinhos exportados: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeSheet(w, documents.ExportedCarsSheet(lines, constants.GetLocation()), format, "carrinhos"+rangeSuffix(from, to))
+}
+
+// Exporta o stock atual, sem armazém indicado é somado o stock de todos
+func exportStock(w http.ResponseWriter, r *http.Request, db *pgxpool.Pool, format string) {
+	stock, err := database.GetStock(db, r.URL.Query().Get("warehouse"))
+	if err != nil {
+		log.Printf("Erro ao calcular o stock: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeSheet(w, documents.StockSheet(stock), format, "stock")
+}
+
+// Exporta os movimentos de stock, com os mesmos filtros de /movements
+func exportMovements(w http.ResponseWriter, r *http.Request, db *pgxpool.Pool, format string) {
+	from, to, ok := getDateRange(w, r)
+	if !ok {
+		return
+	}
+
+	movements, err := database.GetStockMovements(db, r.URL.Query().Get("product"), r.URL.Query().Get("warehouse"), from, to)
+	if err != nil {
+		log.Printf("Erro ao procurar movimentos: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeSheet(w, documents.MovementsSheet(movements, constants.GetLocation()), format, "movimentos"+rangeSuffix(from, to))
+}
+
+// Parte do nome do ficheiro com o intervalo de datas
+func rangeSuffix(from string, to string) string {
+	suffix := ""
+	if from != "" {
+		suffix += "_" + from
+	}
+	if to != "" {
+		suffix += "_" + to
+	}
+	return suffix
+}
+
+// Escreve a folha de cálculo no formato pedido como um ficheiro para descarregar
+func writeSheet(w http.ResponseWriter, sheet documents.Sheet, format string, filename string) {
+	var out bytes.Buffer
+	var err error
+	if format == "xlsx" {
+		err = documents.WriteXLSX(&out, sheet)
+	} else {
+		err = documents.WriteCSV(&out, sheet)
+	}
+	if err != nil {
+		log.Printf("Erro ao gerar a folha de cálculo: %v", err)
+		http.Error(w, "Erro ao gerar a folha de cálculo", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", sheetContentTypes[format])
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+"."+format+`"`)
+	w.Write(out.Bytes())
+}
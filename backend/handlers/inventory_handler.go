@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/Samuel-k276/backend/database"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// RegisterInventoryHandlers registra os handlers dos inventários
+func RegisterInventoryHandlers(mux *http.ServeMux, db *pgxpool.Pool) {
+	// Endpoints para o relatório de diferenças e a sua aprovação
+	mux.HandleFunc("/inventory/", func(w http.ResponseWriter, r *http.Request) {
+		// O caminho será "/inventory/ABC123" ou "/inventory/ABC123/approve"
+		parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/inventory/"), "/")
+		id := parts[0]
+		if id == "" || len(parts) > 2 {
+			http.Error(w, "ID inválido", http.StatusBadRequest)
+			return
+		}
+
+		if len(parts) == 1 && r.Method == http.MethodGet {
+			// Consultar o relatório - com autenticação
+			AuthMiddleware(func(w http.ResponseWriter, r *http.Request) {
+				getInventoryReport(w, db, id)
+			})(w, r)
+		} else if len(parts) == 2 && parts[1] == "approve" && r.Method == http.MethodPost {
+			// Aprovar os ajustes - só administradores
+			AdminMiddleware(func(w http.ResponseWriter, r *http.Request) {
+				approveInventory(w, db, id)
+			})(w, r)
+		} else {
+			http.Error(w, "Método não permitido", http.StatusMethodNotAllowed)
+		}
+	})
+}
+
+func getInventoryReport(w http.ResponseWriter, db *pgxpool.Pool, id string) {
+	report, err := database.GetInventoryReport(db, id)
+	if err != nil {
+		if err == database.ErrInventoryNotFound {
+			http.Error(w, "Inventário não encontrado ou ainda não exportado", http.StatusNotFound)
+		} else {
+			log.Printf("Erro ao procurar inventário: %v", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+func approveInventory(w http.ResponseWriter, db *pgxpool.Pool, id string) {
+	err := database.ApproveInventory(db, id)
+	if err != nil {
+		switch err {
+		case database.ErrInventoryNotFound:
+			http.Error(w, "Inventário não encontrado ou ainda não exportado", http.StatusNotFound)
+		case database.ErrInventoryAlreadyApproved:
+			http.Error(w, "Inventário já aprovado", http.StatusConflict)
+		default:
+			log.Printf("Erro ao aprovar inventário: %v", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	// Devolver o relatório já aprovado
+	getInventoryReport(w, db, id)
+}
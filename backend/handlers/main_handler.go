@@ -28,10 +28,9 @@ func RegisterHandlers(mux *http.ServeMux, db *pgxpool.Pool) {
 	// Carts routes
 	mux.HandleFunc("/cars", AuthMiddleware(GetAllCarsHandler))
 	mux.HandleFunc("/cars/create", CreateCarHandler)
-	mux.HandleFunc("/cars/get", GetCarHandler)
-	mux.HandleFunc("/cars/add-product", AddProductToCarHandler)
-	mux.HandleFunc("/cars/remove-product", RemoveProductFromCarHandler)
-	mux.HandleFunc("/cars/update-quantity", UpdateProductQuantityHandler)
+	mux.HandleFunc("/cars/history", AuthMiddleware(CarHistoryHandler))
+	mux.HandleFunc("/cars/stale", AdminMiddleware(StaleCarsHandler))
+	mux.HandleFunc("/cars/normalize-all", AdminMiddleware(NormalizeAllCarsHandler))
 
 	// Authentication routes
 	RegisterAuthHandlers(mux)
@@ -41,6 +40,18 @@ func RegisterHandlers(mux *http.ServeMux, db *pgxpool.Pool) {
 	RegisterProductHandlers(mux, db)
 	// Donors routes
 	RegisterDonorHandlers(mux, db)
+	// Warehouses routes
+	RegisterWarehouseHandlers(mux, db)
 	// Map routes
 	RegisterMapHandlers(mux)
+	// Stock routes
+	RegisterStockHandlers(mux, db)
+	// Report routes
+	RegisterReportHandlers(mux, db)
+	// Inventory routes
+	RegisterInventoryHandlers(mux, db)
+	// Carts and their lines as resources, with their documents
+	RegisterCarResourceHandlers(mux, db)
+	// Spreadsheet exports routes
+	RegisterExportHandlers(mux, db)
 }
@@ -5,17 +5,28 @@ import (
 	"io"
 	"net/http"
 	"os"
+	"strings"
 
 	"github.com/Samuel-k276/backend/constants"
+	"github.com/Samuel-k276/backend/models"
 )
 
 func RegisterMapHandlers(mux *http.ServeMux) {
 	// Endpoint para upload de mapa
 	mux.HandleFunc("/map", func(w http.ResponseWriter, r *http.Request) {
+		// Cada armazém tem o seu mapa, sem armazém é usado o principal
+		// Só a imagem é de cada armazém, as posições dos produtos são as mesmas em todos
+		path, ok := getWarehouseMapPath(w, r)
+		if !ok {
+			return
+		}
+
 		if r.Method == http.MethodPost {
-			AuthMiddleware(uploadMapHandler)(w, r)
+			AuthMiddleware(func(w http.ResponseWriter, r *http.Request) {
+				uploadMapHandler(w, r, path)
+			})(w, r)
 		} else if r.Method == http.MethodGet {
-			getMapPathHandler(w)
+			getMapPathHandler(w, path)
 		} else {
 			http.Error(w, "Método não permitido", http.StatusMethodNotAllowed)
 		}
@@ -25,10 +36,27 @@ func RegisterMapHandlers(mux *http.ServeMux) {
 
 }
 
-func getMapPathHandler(w http.ResponseWriter) {
-	// Obter o caminho do mapa
-	path := constants.GetMapPath()
+// Devolve o caminho do mapa do armazém pedido, escrevendo o erro se o armazém não existir
+func getWarehouseMapPath(w http.ResponseWriter, r *http.Request) (string, bool) {
+	warehouseID := r.URL.Query().Get("warehouse")
+	if warehouseID == "" {
+		return constants.GetMapPath(), true
+	}
+
+	// O ID faz parte do nome do ficheiro, não pode sair da pasta dos assets
+	if strings.ContainsAny(warehouseID, "/\\.") {
+		http.Error(w, "ID inválido", http.StatusBadRequest)
+		return "", false
+	}
+	if _, err := models.GetWarehouse(GetDB(), warehouseID); err != nil {
+		http.Error(w, "Armazém não encontrado", http.StatusNotFound)
+		return "", false
+	}
+
+	return constants.GetWarehouseMapPath(warehouseID), true
+}
 
+func getMapPathHandler(w http.ResponseWriter, path string) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(map[string]string{
@@ -36,7 +64,7 @@ func getMapPathHandler(w http.ResponseWriter) {
 	})
 }
 
-func uploadMapHandler(w http.ResponseWriter, r *http.Request) {
+func uploadMapHandler(w http.ResponseWriter, r *http.Request, path string) {
 	r.ParseMultipartForm(10 << 20)
 
 	file, _, err := r.FormFile("mapa")
@@ -47,7 +75,6 @@ func uploadMapHandler(w http.ResponseWriter, r *http.Request) {
 	defer file.Close()
 
 	// Caminho final do ficheiro
-	path := constants.GetMapPath()
 	dst, err := os.Create(path)
 	if err != nil {
 		http.Error(w, "Erro ao guardar ficheiro", http.StatusInternalServerError)
@@ -0,0 +1,121 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+
+	"github.com/Samuel-k276/backend/auth"
+)
+
+// Longest name and device kept for a connection
+const maxPresenceField = 60
+
+// Role of the connections opened with the token of the car, without a JWT
+const roleVolunteer = "volunteer"
+
+// Who is using a connection of a car, shown to the other users of the same car
+type Presence struct {
+	ClientID    string    `json:"client_id"`
+	Role        string    `json:"role"`
+	Name        string    `json:"name"`
+	Device      string    `json:"device"`
+	ConnectedAt time.Time `json:"connected_at"`
+}
+
+// Last id given to a connection
+var lastClientID atomic.Uint64
+
+// Function that reads who opens the connection, the name and the device are chosen by the app
+// The role comes from the JWT of the user, never from the token of the car, without it the user is a volunteer
+func newPresence(r *http.Request) Presence {
+
+	role := roleVolunteer
+	if claims, err := auth.VerifyToken(userToken(r)); err == nil {
+		role = claims.Role
+	}
+
+	// Without a device the browser tells which one it is
+	device := r.URL.Query().Get("device")
+	if strings.TrimSpace(device) == "" {
+		device = r.UserAgent()
+	}
+
+	return Presence{
+		ClientID:    strconv.FormatUint(lastClientID.Add(1), 10),
+		Role:        role,
+		Name:        presenceField(r.URL.Query().Get("name")),
+		Device:      presenceField(device),
+		ConnectedAt: time.Now(),
+	}
+}
+
+// JWT of the user that opens the connection, in the Authorization header or, as browsers can not
+// send headers in a websocket, in the jwt parameter of the URL
+func userToken(r *http.Request) string {
+	if token := auth.ExtractTokenFromRequest(r); token != "" {
+		return token
+	}
+	return r.URL.Query().Get("jwt")
+}
+
+// Cleans a name or device sent by the app
+func presenceField(value string) string {
+	value = strings.TrimSpace(value)
+	if utf8.RuneCountInString(value) > maxPresenceField {
+		value = string([]rune(value)[:maxPresenceField])
+	}
+	return value
+}
+
+// Function that tells the other users of the car that someone joined or left
+// Who joined is not told about itself, it gets the PresenceList instead
+func broadcastPresence(id_car string, action string, presence Presence) {
+
+	msg, err := json.Marshal(map[string]interface{}{
+		"action": action,
+		"id_car": id_car,
+		"client": presence,
+	})
+	if err != nil {
+		log.Println("Error encoding message to JSON:", err)
+		return
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	for _, client := range cartClients[id_car] {
+		if client.synced && client.presence.ClientID != presence.ClientID {
+			client.queue(msg)
+		}
+	}
+}
+
+// Function that sends the list of who is using the car to one connection, with the id of that connection
+func sendPresenceList(client *wsClient, id_car string) {
+	sendToClient(client, map[string]interface{}{
+		"action":  "PresenceList",
+		"id_car":  id_car,
+		"you":     client.presence.ClientID,
+		"clients": getCarPresence(id_car),
+	})
+}
+
+// Gets who is using the car, the first to connect first
+func getCarPresence(id_car string) []Presence {
+	mu.Lock()
+	defer mu.Unlock()
+
+	// The connections are kept in the order they were opened
+	clients := []Presence{}
+	for _, client := range cartClients[id_car] {
+		clients = append(clients, client.presence)
+	}
+	return clients
+}
@@ -5,6 +5,7 @@ import (
 	"net/http"
 	"strings"
 
+	"github.com/Samuel-k276/backend/auth"
 	"github.com/Samuel-k276/backend/models"
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
@@ -17,10 +18,12 @@ type productRequest struct {
 }
 
 type productUpdateRequest struct {
-	Name      string `json:"name"`
-	Unit      string `json:"unit"`
-	PositionX int    `json:"position_x"`
-	PositionY int    `json:"position_y"`
+	Name           string   `json:"name"`
+	Unit           string   `json:"unit"`
+	PositionX      int      `json:"position_x"`
+	PositionY      int      `json:"position_y"`
+	MinQuantity    *float64 `json:"min_quantity"`    // Opcional, sem valor mantém o atual
+	TargetQuantity *float64 `json:"target_quantity"` // Opcional, sem valor mantém o atual
 }
 
 // RegisterProductHandlers registra os handlers específicos de produtos
@@ -134,7 +137,7 @@ func updateProduct(w http.ResponseWriter, r *http.Request, db *pgxpool.Pool, id
 	}
 
 	// Verificando se o produto existe
-	_, err := models.GetProduct(db, id)
+	product, err := models.GetProduct(db, id)
 	if err != nil {
 		if err == pgx.ErrNoRows {	
 			http.Error(w, "Produto não encontrado", http.StatusNotFound)
@@ -144,7 +147,29 @@ func updateProduct(w http.ResponseWriter, r *http.Request, db *pgxpool.Pool, id
 		return
 	}
 
-	if err := models.UpdateProduct(db, id, req.Name, req.Unit, req.PositionX, req.PositionY); err != nil {
+	// Os limites de stock só podem ser alterados por administradores
+	if req.MinQuantity != nil || req.TargetQuantity != nil {
+		claims, err := auth.VerifyToken(auth.ExtractTokenFromRequest(r))
+		if err != nil || claims.Role != "admin" {
+			http.Error(w, "Só administradores podem alterar o mínimo e o objetivo de stock", http.StatusForbidden)
+			return
+		}
+	}
+
+	// Os limites de stock só mudam se forem enviados
+	minQuantity, targetQuantity := product.MinQuantity, product.TargetQuantity
+	if req.MinQuantity != nil {
+		minQuantity = *req.MinQuantity
+	}
+	if req.TargetQuantity != nil {
+		targetQuantity = *req.TargetQuantity
+	}
+	if minQuantity < 0 || targetQuantity < 0 || (targetQuantity > 0 && targetQuantity < minQuantity) {
+		http.Error(w, "Quantidades inválidas, o objetivo não pode ser menor que o mínimo", http.StatusBadRequest)
+		return
+	}
+
+	if err := models.UpdateProduct(db, id, req.Name, req.Unit, req.PositionX, req.PositionY, minQuantity, targetQuantity); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
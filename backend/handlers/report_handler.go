@@ -0,0 +1,119 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Samuel-k276/backend/constants"
+	"github.com/Samuel-k276/backend/database"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Número de dias considerado por omissão no relatório de validades
+const DefaultExpiryDays = 30
+
+// RegisterReportHandlers registra os handlers dos relatórios
+func RegisterReportHandlers(mux *http.ServeMux, db *pgxpool.Pool) {
+	// Endpoint para o relatório de validades - com autenticação
+	mux.HandleFunc("/reports/expiry", AuthMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Método não permitido", http.StatusMethodNotAllowed)
+			return
+		}
+		getExpiryReport(w, r, db)
+	}))
+
+	// Endpoint para o relatório de quebras - com autenticação
+	mux.HandleFunc("/reports/write-offs", AuthMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Método não permitido", http.StatusMethodNotAllowed)
+			return
+		}
+		getWriteOffReport(w, r, db)
+	}))
+
+	// Endpoint com os códigos de motivo de quebra - sem autenticação
+	mux.HandleFunc("/reports/write-offs/reasons", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Método não permitido", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"subtypes": database.SaidaSubtypes,
+			"reasons":  database.WriteOffReasons,
+		})
+	})
+}
+
+func getExpiryReport(w http.ResponseWriter, r *http.Request, db *pgxpool.Pool) {
+	days := DefaultExpiryDays
+	if daysQuery := r.URL.Query().Get("days"); daysQuery != "" {
+		var err error
+		days, err = strconv.Atoi(daysQuery)
+		if err != nil || days < 0 {
+			http.Error(w, "Número de dias inválido", http.StatusBadRequest)
+			return
+		}
+	}
+
+	report, err := database.GetExpiryReport(db, r.URL.Query().Get("warehouse"), days, time.Now().In(constants.GetLocation()))
+	if err != nil {
+		log.Printf("Erro ao gerar relatório de validades: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+func getWriteOffReport(w http.ResponseWriter, r *http.Request, db *pgxpool.Pool) {
+	from, to, ok := getDateRange(w, r)
+	if !ok {
+		return
+	}
+
+	totals, err := database.GetWriteOffReport(db, from, to)
+	if err != nil {
+		log.Printf("Erro ao gerar relatório de quebras: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(totals)
+}
+
+// BroadcastExpiryDigest gera o relatório de validades e envia-o aos administradores ligados
+func BroadcastExpiryDigest(db *pgxpool.Pool) {
+	report, err := database.GetExpiryReport(db, "", DefaultExpiryDays, time.Now().In(constants.GetLocation()))
+	if err != nil {
+		log.Printf("Erro ao gerar relatório de validades: %v", err)
+		return
+	}
+
+	broadcastAdmin(map[string]interface{}{
+		"action": "ExpiryDigest",
+		"report": report,
+	})
+}
+
+// Lê o intervalo de datas opcional (from e to) no formato YYYY-MM-DD, escrevendo o erro se for inválido
+func getDateRange(w http.ResponseWriter, r *http.Request) (string, string, bool) {
+	from := r.URL.Query().Get("from")
+	to := r.URL.Query().Get("to")
+	for _, date := range []string{from, to} {
+		if date == "" {
+			continue
+		}
+		if _, err := time.Parse(database.ExpirationLayout, date); err != nil {
+			http.Error(w, "Data inválida, use o formato YYYY-MM-DD", http.StatusBadRequest)
+			return "", "", false
+		}
+	}
+	return from, to, true
+}
@@ -0,0 +1,129 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/Samuel-k276/backend/database"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// RegisterStockHandlers registra os handlers do stock
+func RegisterStockHandlers(mux *http.ServeMux, db *pgxpool.Pool) {
+	// Endpoint para consultar o stock de todos os produtos - sem autenticação
+	mux.HandleFunc("/stock", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Método não permitido", http.StatusMethodNotAllowed)
+			return
+		}
+		getStock(w, r, db)
+	})
+
+	// Endpoint para consultar o stock de um produto - sem autenticação
+	mux.HandleFunc("/stock/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Método não permitido", http.StatusMethodNotAllowed)
+			return
+		}
+
+		// Extrair o ID da URL
+		id := getStockProductIDFromURL(r.URL.Path)
+		if id == "" {
+			http.Error(w, "ID inválido", http.StatusBadRequest)
+			return
+		}
+		getProductStock(w, r, db, id)
+	})
+
+	// Endpoint para consultar os produtos abaixo do mínimo - com autenticação
+	mux.HandleFunc("/needs", AuthMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Método não permitido", http.StatusMethodNotAllowed)
+			return
+		}
+		getNeeds(w, db)
+	}))
+
+	// Endpoint para consultar o registo de movimentos - com autenticação
+	mux.HandleFunc("/movements", AuthMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Método não permitido", http.StatusMethodNotAllowed)
+			return
+		}
+		getMovements(w, r, db)
+	}))
+}
+
+func getMovements(w http.ResponseWriter, r *http.Request, db *pgxpool.Pool) {
+	// Filtros opcionais por produto, armazém e datas
+	productID := r.URL.Query().Get("product")
+	warehouseID := r.URL.Query().Get("warehouse")
+	from, to, ok := getDateRange(w, r)
+	if !ok {
+		return
+	}
+
+	movements, err := database.GetStockMovements(db, productID, warehouseID, from, to)
+	if err != nil {
+		log.Printf("Erro ao procurar movimentos: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(movements)
+}
+
+func getStock(w http.ResponseWriter, r *http.Request, db *pgxpool.Pool) {
+	// Sem armazém indicado é somado o stock de todos
+	stock, err := database.GetStock(db, r.URL.Query().Get("warehouse"))
+	if err != nil {
+		log.Printf("Erro ao calcular o stock: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stock)
+}
+
+func getProductStock(w http.ResponseWriter, r *http.Request, db *pgxpool.Pool, id string) {
+	stock, err := database.GetProductStock(db, r.URL.Query().Get("warehouse"), id)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			http.Error(w, "Produto não encontrado", http.StatusNotFound)
+		} else {
+			log.Printf("Erro ao calcular o stock do produto: %v", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stock)
+}
+
+func getNeeds(w http.ResponseWriter, db *pgxpool.Pool) {
+	needs, err := database.GetNeeds(db)
+	if err != nil {
+		log.Printf("Erro ao calcular as necessidades: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(needs)
+}
+
+func getStockProductIDFromURL(path string) string {
+	// O caminho será "/stock/ABC123"
+	parts := strings.Split(path, "/")
+	if len(parts) != 3 {
+		return ""
+	}
+
+	return parts[2]
+}
@@ -0,0 +1,193 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/Samuel-k276/backend/models"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type warehouseRequest struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// RegisterWarehouseHandlers registra os handlers específicos de armazéns
+func RegisterWarehouseHandlers(mux *http.ServeMux, db *pgxpool.Pool) {
+	// Endpoint para listar todos os armazéns - GET sem autenticação, POST com autenticação
+	mux.HandleFunc("/warehouses", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			// GET não precisa de autenticação
+			getWarehouses(w, db)
+		} else {
+			// POST continua com autenticação
+			AuthMiddleware(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method == http.MethodPost {
+					createWarehouse(w, r, db)
+				} else {
+					w.WriteHeader(http.StatusMethodNotAllowed)
+				}
+			})(w, r)
+		}
+	})
+
+	// Endpoints para operações em um armazém específico
+	mux.HandleFunc("/warehouses/", func(w http.ResponseWriter, r *http.Request) {
+		// Extrair o ID da URL
+		id := getWarehouseIDFromURL(r.URL.Path)
+		if id == "" {
+			http.Error(w, "ID inválido", http.StatusBadRequest)
+			return
+		}
+
+		if r.Method == http.MethodGet {
+			// GET não precisa de autenticação
+			getWarehouse(w, db, id)
+		} else {
+			// PUT e DELETE continuam com autenticação
+			AuthMiddleware(func(w http.ResponseWriter, r *http.Request) {
+				switch r.Method {
+				case http.MethodPut:
+					updateWarehouse(w, r, db, id)
+				case http.MethodDelete:
+					deleteWarehouse(w, db, id)
+				default:
+					w.WriteHeader(http.StatusMethodNotAllowed)
+				}
+			})(w, r)
+		}
+	})
+}
+
+func getWarehouses(w http.ResponseWriter, db *pgxpool.Pool) {
+	warehouses, err := models.GetWarehouses(db)
+	if err != nil {
+		log.Printf("Erro ao procurar armazéns: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(warehouses)
+}
+
+func getWarehouse(w http.ResponseWriter, db *pgxpool.Pool, id string) {
+	warehouse, err := models.GetWarehouse(db, id)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			log.Printf("Armazém não encontrado: %s", id)
+			http.Error(w, "Armazém não encontrado", http.StatusNotFound)
+		} else {
+			log.Printf("Erro ao procurar armazém: %v", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(warehouse)
+}
+
+func createWarehouse(w http.ResponseWriter, r *http.Request, db *pgxpool.Pool) {
+	var req warehouseRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Printf("Erro ao decodificar requisição: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Validação simples
+	if req.ID == "" || req.Name == "" {
+		http.Error(w, "ID e nome são obrigatórios", http.StatusBadRequest)
+		return
+	}
+
+	err := models.CreateWarehouse(db, req.ID, req.Name)
+	if err != nil {
+		log.Printf("Erro ao criar armazém: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]string{"id": req.ID})
+}
+
+func updateWarehouse(w http.ResponseWriter, r *http.Request, db *pgxpool.Pool, id string) {
+	var req warehouseRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Printf("Erro ao decodificar requisição: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Validação simples
+	if req.Name == "" {
+		http.Error(w, "Nome é obrigatório", http.StatusBadRequest)
+		return
+	}
+
+	// Verificando se o armazém existe
+	_, err := models.GetWarehouse(db, id)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			log.Printf("Armazém não encontrado: %s", id)
+			http.Error(w, "Armazém não encontrado", http.StatusNotFound)
+		} else {
+			log.Printf("Erro ao procurar armazém: %v", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	if err := models.UpdateWarehouse(db, id, req.Name); err != nil {
+		log.Printf("Erro ao atualizar armazém: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	updatedWarehouse, _ := models.GetWarehouse(db, id)
+	json.NewEncoder(w).Encode(updatedWarehouse)
+}
+
+func deleteWarehouse(w http.ResponseWriter, db *pgxpool.Pool, id string) {
+	// Verificando se o armazém existe
+	_, err := models.GetWarehouse(db, id)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			http.Error(w, "Armazém não encontrado", http.StatusNotFound)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	if err := models.DeleteWarehouse(db, id); err != nil {
+		if errors.Is(err, models.ErrWarehouseInUse) {
+			http.Error(w, "O armazém tem carrinhos ou movimentos de stock e não pode ser apagado", http.StatusConflict)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func getWarehouseIDFromURL(path string) string {
+	// O caminho será "/warehouses/BENFICA"
+	parts := strings.Split(path, "/")
+	if len(parts) != 3 {
+		return ""
+	}
+
+	return parts[2]
+}
@@ -2,10 +2,13 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
+	"runtime/debug"
 	"sync"
 
+	"github.com/Samuel-k276/backend/auth"
 	"github.com/Samuel-k276/backend/database"
 	"github.com/gorilla/websocket"
 	"github.com/jackc/pgx/v5/pgxpool"
@@ -37,9 +40,22 @@ var upgrader = websocket.Upgrader{
 }
 
 // Map with all the connections by car
-var cartClients = make(map[string][]*websocket.Conn)
+var cartClients = make(map[string][]*wsClient)
+
+// Error sent when someone that is not an admin tries to export without checking the stock
+var errOverrideNotAllowed = errors.New("only an admin can export without enough stock")
+
+// Error sent when someone with only the token of the car tries to change its state or delete it, that needs a JWT
+var errStatusNeedsLogin = errors.New("only a logged in user (JWT) can change the state of the car or delete it")
+
+// Error sent when a message is about a car that is not the one of the connection
+var errOtherCar = errors.New("the connection does not give access to that car")
+
+// Connections of the admins, they receive the notifications that are not about a single car
+var adminClients []*wsClient
 
 // Necessary because the Go routines could touch the map at the same time
+// It only guards the lists, the messages are written by the goroutine of each connection
 var mu sync.Mutex
 
 // Main handler of the websocket connection
@@ -52,6 +68,11 @@ func HandleWebSocket(db *pgxpool.Pool, w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Only who has the token of the car can edit it
+	if !authorizeCarAccess(db, w, r, id_car) {
+		return
+	}
+
 	// Upgrading the connection
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
@@ -60,29 +81,97 @@ func HandleWebSocket(db *pgxpool.Pool, w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Closing the connection when the program ends
-	defer conn.Close()
+	client := newWSClient(conn)
+	client.presence = newPresence(r)
+	defer client.close()
+
+	// Opening the car counts as activity, so it is not cancelled while someone is using it
+	// It is saved before the car is read, so the car that is sent is not flagged as stale anymore
+	if err := database.TouchCar(db, id_car); err != nil {
+		log.Println("Error saving the activity of the car:", err)
+	}
+
+	// Registers the connection, it gets the whole car and then the diffs that apply on it
+	if err := joinCar(db, client, id_car); err != nil {
+		log.Println("Error sending the car to the client:", err)
+	}
+
+	// Removing the connection from the connection map when the program ends, the others see who left
+	defer func() {
+		removeConnection(id_car, client)
+		broadcastPresence(id_car, "PresenceLeave", client.presence)
+	}()
+
+	// The others see who joined, and who joined sees who is already there
+	broadcastPresence(id_car, "PresenceJoin", client.presence)
+	sendPresenceList(client, id_car)
+
+	// Loop to receive the messages, until the client leaves, stops answering or is dropped
+	client.readPump(func(msg []byte) {
+		processMessage(db, client, id_car, msg)
+	})
+}
+
+// Handler of the websocket connection of the admins, the JWT goes in the URL because browsers can not send headers
+func HandleAdminWebSocket(w http.ResponseWriter, r *http.Request) {
+
+	claims, err := auth.VerifyToken(r.URL.Query().Get("token"))
+	if err != nil || claims.Role != "admin" {
+		http.Error(w, "Acesso reservado a administradores", http.StatusUnauthorized)
+		return
+	}
+
+	// Upgrading the connection
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Println("Error upgrading to Websockets", err)
+		return
+	}
+	client := newWSClient(conn)
+	defer client.close()
 
-	// Registers the connection -> mu is because of the Go routines accessing the same data
 	mu.Lock()
-	cartClients[id_car] = append(cartClients[id_car], conn)
+	adminClients = append(adminClients, client)
 	mu.Unlock()
 
-	// Removing the connection from the connection map when the program ends
-	defer removeConnection(id_car, conn)
+	defer removeAdminConnection(client)
 
-	// Loop to receive the messages
-	for {
-		_, msg, err := conn.ReadMessage()
-		if err != nil {
-			log.Println("Error reading the message", err)
+	// The admins only receive, reading is needed to know when they leave and to get the pongs
+	client.readPump(func(msg []byte) {})
+}
+
+// Function to remove a specific admin connection
+func removeAdminConnection(conn *wsClient) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	for i, client := range adminClients {
+		if client == conn {
+			adminClients = slices.Delete(adminClients, i, i+1)
 			break
 		}
-		processMessage(db, id_car, msg) // Fixed: passing conn to processMessage
+	}
+}
+
+// Function that sends a message to all the admins connected
+func broadcastAdmin(response map[string]interface{}) {
+
+	msg, err := json.Marshal(response)
+	if err != nil {
+		log.Println("Error encoding message to JSON:", err)
+		return
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	for _, client := range adminClients {
+		client.queue(msg)
 	}
 }
 
 // Function to remove a specific connection
-func removeConnection(id_car string, conn *websocket.Conn) {
+func removeConnection(id_car string, conn *wsClient) {
 	// To be able to access the map with the clients
 	mu.Lock()
 	defer mu.Unlock()
@@ -96,130 +185,248 @@ func removeConnection(id_car string, conn *websocket.Conn) {
 			break
 		}
 	}
+
+	// The last one to leave takes the state of the car with it
+	if len(cartClients[id_car]) == 0 {
+		delete(cartClients, id_car)
+		dropCarSync(id_car)
+	}
 }
 
 // Handles the messages from the user
-func processMessage(db *pgxpool.Pool, id_car string, msg []byte) {
-	// Putting the message into a map to be easier to access
-	var message map[string]interface{}
-	if err := json.Unmarshal(msg, &message); err != nil {
+// Every message gets an Ack or an Error, only in the connection that sent it, with its request_id
+func processMessage(db *pgxpool.Pool, client *wsClient, id_car string, msg []byte) {
+	var request wsEnvelope
+
+	// A bad message must not close the connection of the user
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("Panic handling the message %q of the car %s: %v\n%s", request.Action, id_car, r, debug.Stack())
+			sendError(client, id_car, request, errInternal)
+		}
+	}()
+
+	if err := decodeMessage(msg, &request); err != nil {
 		log.Println("Error in translating the message sent by the user:", err)
+		sendError(client, id_car, request, err)
 		return
 	}
 
-	// Getting the action
-	action := message["action"].(string)
+	// The connection only gives access to its own car
+	if request.IDCar != "" && request.IDCar != id_car {
+		log.Println("Message for another car in the connection of", id_car)
+		sendError(client, request.IDCar, request, errOtherCar)
+		return
+	}
 
-	// Calling the functions based on the action
-	switch action {
+	if err := handleAction(newCarService(db), client, id_car, request, msg); err != nil {
+		log.Printf("Error handling the action %s of the car %s: %v", request.Action, id_car, err)
+		if isConflict(err) {
+			sendConflict(db, client, id_car, request, msg, err)
+		} else if request.Action == "Export" {
+			sendExportError(client, id_car, request, err)
+		} else {
+			sendError(client, id_car, request, err)
+		}
+		return
+	}
+
+	sendToClient(client, map[string]interface{}{
+		"action":     "Ack",
+		"id_car":     id_car,
+		"request":    request.Action,
+		"request_id": request.RequestID,
+	})
+}
+
+// Reads the message into the struct of its action and calls the service, the same one of the REST endpoints
+func handleAction(carts *carService, client *wsClient, id_car string, request wsEnvelope, msg []byte) error {
+
+	switch request.Action {
 	case "DeleteCar":
-		id_car := message["id_car"].(string)
-		database.DeleteCarId(db, id_car)
+		// Deleting can not be undone, the token of the car is not enough
+		if client.presence.Role == roleVolunteer {
+			return errStatusNeedsLogin
+		}
+		// The exported cars stay in the history
+		return carts.Delete(id_car)
 
+	// Only who asks gets the whole car
 	case "GetCar":
-		id_car := message["id_car"].(string)
-		broadcastCartUpdate(db, id_car)
+		return sendCarSnapshot(carts.db, client, id_car)
 
-	case "Export":
-		id_car_val, ok := message["id_car"]
-		if !ok {
-			// "id_car" não existe no map
-			log.Println("id_car missing in message")
-			return
+	// The client missed updates, it gets the ones after its seq or the whole car
+	case "Sync":
+		var message wsSyncMessage
+		if err := decodeMessage(msg, &message); err != nil {
+			return err
 		}
+		return syncCar(carts.db, client, id_car, message.Seq)
 
-		id_car, ok := id_car_val.(string)
-		if !ok {
-			// "id_car" não é string
-			log.Println("id_car is not a string")
-			return
+	case "Export":
+		// Only an admin can export a Saída without enough stock
+		var message wsExportMessage
+		if err := decodeMessage(msg, &message); err != nil {
+			return err
 		}
+		return carts.Export(id_car, message.Override, message.Token)
 
-		// Agora podes usar id_car com segurança
-		database.ChangeDateCar(db, id_car)
+	case "SetCarSubtype":
+		var message wsSubtypeMessage
+		if err := decodeMessage(msg, &message); err != nil {
+			return err
+		}
+		return carts.SetSubtype(id_car, message.Version, message.Subtype)
 
 	// I will choose between adding or updating a product
-	case "AddProductCar":
-		// Extract all required fields
-		idFloat := message["id"].(float64)
-		id := int(idFloat)
-		idCar := message["id_car"].(string)
-		idProduct := message["id_product"].(string)
-		quantity := message["quantity"].(float64)
-		expiration := message["expiration"].(string)
-		description := message["description"].(string)
-
-		// If the product is not in the db
-		if id == 0 {
-
-			// Call the function to add the product to the car
-			_, err := database.AddProductCar(db, idCar, idProduct, quantity, expiration, description)
-			if err != nil {
-				log.Println("Error handling the function to add the product to the db:", err)
-				return
-			}
-		} else {
+	case "AddProductCar", "EditProductCar":
+		var message wsLineMessage
+		if err := decodeMessage(msg, &message); err != nil {
+			return err
+		}
+
+		// Editing the current product
+		if message.ID != 0 {
+			return carts.EditLine(id_car, message.ID, message.Version, message.CarLineInput)
+		}
 
-			// Editing the current product
-			err := database.EditProductCar(db, id, quantity, expiration, description)
-			if err != nil {
-				log.Println("Error handling the function to edit the product in the db:", err)
-				return
-			}
+		// Without the id of the line it is a new product
+		pick, err := carts.AddLine(id_car, message.CarLineInput)
+		if err != nil {
+			return err
 		}
 
-		// Give the updated car to all users
-		broadcastCartUpdate(db, id_car)
+		// Saída cars get the lots to take first, only who added the product needs them
+		if pick != nil {
+			sendToClient(client, map[string]interface{}{
+				"action":      "PickSuggestion",
+				"id_car":      id_car,
+				"request_id":  request.RequestID,
+				"id_product":  pick.IDProduct,
+				"quantity":    pick.Quantity,
+				"lots":        pick.Lots,
+				"expired":     pick.Expired,
+				"missing":     pick.Missing,
+				"auto_filled": pick.AutoFilled,
+			})
+		}
+		return nil
 
 	case "DeleteProductCar":
-		idFloat := message["id"].(float64)
-		id := int(idFloat)
-		idCar := message["id_car"].(string)
+		var message wsLineRefMessage
+		if err := decodeMessage(msg, &message); err != nil {
+			return err
+		}
+		return carts.RemoveLine(id_car, message.ID, message.Version)
 
-		err := database.DeleteProductCar(db, id)
-		if err != nil {
-			log.Println("Error handling the function to remove the product in the db:", err)
-			return
+	// Information of the export form
+	case "UpdateCarInfo":
+		var message wsInfoMessage
+		if err := decodeMessage(msg, &message); err != nil {
+			return err
 		}
+		return carts.UpdateInfo(id_car, message.Version, message.CarMetadata)
 
-		// Give the updated car to all users
-		broadcastCartUpdate(db, idCar)
+	// Who else is using the car
+	case "GetPresence":
+		sendPresenceList(client, id_car)
+		return nil
 
-	case "EditProductCar":
-		// Extract all the data needed
-		idFloat := message["id"].(float64)
-		id := int(idFloat)
-		idCar := message["id_car"].(string)
-		quantity := message["quantity"].(float64)
-		expiration := message["expiration"].(string)
-		description := message["description"].(string)
+	// The user answers the StaleWarning and keeps the car
+	case "KeepOpen":
+		return carts.KeepOpen(id_car)
 
-		err := database.EditProductCar(db, id, quantity, expiration, description)
-		if err != nil {
-			log.Println("Error handling the function to edit the product in the db:", err)
-			return
+	// Changes of the state of the car, exporting is done by the Export action
+	case "Lock", "Unlock", "Cancel":
+		if client.presence.Role == roleVolunteer {
+			return errStatusNeedsLogin
 		}
+		return carts.SetStatus(id_car, statusActions[request.Action])
+	}
+
+	return errUnknownAction
+}
 
-		// Give the updated car to all users
-		broadcastCartUpdate(db, idCar)
+// Status the car goes to with each action
+var statusActions = map[string]string{
+	"Lock":   database.CarStatusLocked,
+	"Unlock": database.CarStatusOpen,
+	"Cancel": database.CarStatusCancelled,
+}
+
+// Function that tells the user why the car could not be exported
+func sendExportError(client *wsClient, id_car string, request wsEnvelope, err error) {
+
+	response := map[string]interface{}{
+		"action":     "ExportError",
+		"id_car":     id_car,
+		"request_id": request.RequestID,
+		"error":      err.Error(),
+	}
+
+	// Lines that need to be fixed before exporting again, the errors can come wrapped
+	var missing *database.MissingReasonError
+	if errors.As(err, &missing) {
+		response["lines"] = missing.Lines
+	}
+	var shortage *database.StockShortageError
+	if errors.As(err, &shortage) {
+		response["shortages"] = shortage.Shortages
+	}
+
+	sendToClient(client, response)
+}
+
+// Function that tells the user the change was made on an old version, with what is there now
+// so the change can be done again over it
+func sendConflict(db *pgxpool.Pool, client *wsClient, id_car string, request wsEnvelope, msg []byte, err error) {
+
+	// The line conflicts are about the line of the message
+	var line wsLineRefMessage
+	json.Unmarshal(msg, &line)
+
+	response := conflictState(db, id_car, line.ID, err)
+	response["action"] = "Conflict"
+	response["id_car"] = id_car
+	response["request"] = request.Action
+	response["request_id"] = request.RequestID
+	sendToClient(client, response)
+}
+
+// Function that tells the user why the action was not done
+// The errors of the fields of the message say which field is wrong
+func sendError(client *wsClient, id_car string, request wsEnvelope, err error) {
+
+	response := map[string]interface{}{
+		"action":     "Error",
+		"id_car":     id_car,
+		"request":    request.Action,
+		"request_id": request.RequestID,
+		"error":      err.Error(),
+	}
+
+	var fieldErr *wsFieldError
+	if errors.As(err, &fieldErr) {
+		response["field"] = fieldErr.Field
 	}
+
+	sendToClient(client, response)
 }
 
-// Function that tells the other users how the car is now
-func broadcastCartUpdate(db *pgxpool.Pool, id_car string) {
+// Function that sends a message only to the user that made the request
+func sendToClient(client *wsClient, response map[string]interface{}) {
 
-	// Get all the products in the car
-	cart, err := database.GetCar(db, id_car)
+	msg, err := json.Marshal(response)
 	if err != nil {
-		log.Println("Error retrieving cart from database:", err)
+		log.Println("Error encoding message to JSON:", err)
 		return
 	}
 
-	response := map[string]interface{}{
-		"action":   "UpdateCar",
-		"id_car":   id_car,
-		"products": cart.Products,
-	}
+	client.queue(msg)
+}
+
+// Function that sends a message to all the users of the car
+func broadcastToCar(id_car string, response map[string]interface{}) {
 
 	cartJSON, err := json.Marshal(response)
 	if err != nil {
@@ -231,11 +438,10 @@ func broadcastCartUpdate(db *pgxpool.Pool, id_car string) {
 	mu.Lock()
 	defer mu.Unlock()
 
+	// The message is only queued, a slow user is dropped instead of making the others wait
 	for _, client := range cartClients[id_car] {
-		// Sending the cart to all users
-		if err := client.WriteMessage(websocket.TextMessage, cartJSON); err != nil {
-			log.Println("Error sending message to client:", err)
-			client.Close()
+		if client.synced {
+			client.queue(cartJSON)
 		}
 	}
 }
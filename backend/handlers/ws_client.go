@@ -0,0 +1,138 @@
+package handlers
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// Time a write can take, a client that does not read for that long is dropped
+	writeWait = 10 * time.Second
+
+	// Time without hearing from the client before the connection is closed, the pings keep it shorter
+	pongWait = 60 * time.Second
+
+	// Pings are sent before the client runs out of pongWait
+	pingPeriod = (pongWait * 9) / 10
+
+	// Biggest message accepted from a client
+	maxMessageSize = 64 * 1024
+
+	// Messages waiting to be written to a client, a client with a full queue is too slow and is dropped
+	sendQueueSize = 256
+)
+
+// A websocket connection, it is only written by its own goroutine (writePump) so a slow phone
+// never makes the others wait
+type wsClient struct {
+	conn *websocket.Conn
+	send chan []byte
+
+	// Closed when the connection has to end, by the reader, the writer or a full queue
+	done      chan struct{}
+	closeOnce sync.Once
+
+	// Who is using the connection, only for the connections of the cars
+	presence Presence
+
+	// Guarded by mu, a connection of a car only gets the messages sent to everybody after it got the whole car
+	synced bool
+}
+
+// Function that wraps the connection and starts writing to it
+func newWSClient(conn *websocket.Conn) *wsClient {
+	client := &wsClient{
+		conn: conn,
+		send: make(chan []byte, sendQueueSize),
+		done: make(chan struct{}),
+	}
+	go client.writePump()
+	return client
+}
+
+// Puts the message in the queue of the client without waiting
+// A client whose queue is full is not keeping up and is dropped
+func (c *wsClient) queue(msg []byte) {
+	select {
+	case <-c.done:
+		return
+	default:
+	}
+
+	select {
+	case c.send <- msg:
+	default:
+		log.Println("Dropping a websocket client that does not keep up with the messages")
+		c.close()
+	}
+}
+
+// Ends the connection, the writer says goodbye to the client and closes it
+func (c *wsClient) close() {
+	c.closeOnce.Do(func() {
+		close(c.done)
+	})
+}
+
+// Writes the messages of the queue and the pings, it is the only one writing to the connection
+func (c *wsClient) writePump() {
+	ticker := time.NewTicker(pingPeriod)
+	defer func() {
+		ticker.Stop()
+		c.close()
+		c.conn.Close()
+	}()
+
+	for {
+		select {
+		case msg := <-c.send:
+			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := c.conn.WriteMessage(websocket.TextMessage, msg); err != nil {
+				log.Println("Error sending message to client:", err)
+				return
+			}
+
+		case <-ticker.C:
+			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				log.Println("Error sending ping to client:", err)
+				return
+			}
+
+		case <-c.done:
+			message := websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")
+			c.conn.WriteControl(websocket.CloseMessage, message, time.Now().Add(writeWait))
+			return
+		}
+	}
+}
+
+// Reads the messages of the client until the connection ends, each one is given to handle
+// A client that answers no ping for pongWait is taken as gone
+func (c *wsClient) readPump(handle func(msg []byte)) {
+	defer c.close()
+
+	c.conn.SetReadLimit(maxMessageSize)
+	c.conn.SetReadDeadline(time.Now().Add(pongWait))
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(time.Now().Add(pongWait))
+	})
+
+	for {
+		_, msg, err := c.conn.ReadMessage()
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseNormalClosure) {
+				log.Println("Error reading the message", err)
+			}
+			return
+		}
+
+		handle(msg)
+
+		// The time handling the message does not count as silence of the client
+		c.conn.SetReadDeadline(time.Now().Add(pongWait))
+	}
+}
@@ -0,0 +1,162 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+
+	"github.com/Samuel-k276/backend/database"
+)
+
+// Errors of the messages that could not be handled
+var (
+	errInvalidMessage = errors.New("the message is not a valid JSON object")
+	errUnknownAction  = errors.New("unknown action")
+	errInternal       = errors.New("internal error, the action was not done")
+)
+
+// Error of a field of the message with the wrong type or value, the field goes in the Error reply
+type wsFieldError struct {
+	Field   string
+	Problem string
+}
+
+func (e *wsFieldError) Error() string {
+	return "invalid message: " + e.Field + " " + e.Problem
+}
+
+// Fields that every message has, the request_id is sent back in the Ack or Error of the message
+type wsEnvelope struct {
+	Action    string `json:"action"`
+	RequestID string `json:"request_id"`
+	IDCar     string `json:"id_car"`
+}
+
+func (m *wsEnvelope) validate() error {
+	if m.Action == "" {
+		return &wsFieldError{"action", "is required"}
+	}
+	return nil
+}
+
+// Message of AddProductCar and EditProductCar, without the id of the line it is a new product
+// An edit has the version of the line that was edited
+type wsLineMessage struct {
+	ID      int `json:"id"`
+	Version int `json:"version"`
+	CarLineInput
+}
+
+// Also leaves the expiration as it is saved
+func (m *wsLineMessage) validate() error {
+	if m.ID < 0 {
+		return &wsFieldError{"id", "can not be negative"}
+	}
+	if m.ID == 0 && m.IDProduct == "" {
+		return &wsFieldError{"id_product", "is required to add a product"}
+	}
+	if m.ID > 0 && m.Version <= 0 {
+		return &wsFieldError{"version", "of the line is required to edit it"}
+	}
+	if m.Quantity <= 0 {
+		return &wsFieldError{"quantity", "must be above zero"}
+	}
+	if m.Reason != "" && !database.IsValidReason(m.Reason) {
+		return &wsFieldError{"reason", "is not a known reason code"}
+	}
+
+	expiration, err := parseExpiration(m.Expiration)
+	if err != nil {
+		return &wsFieldError{"expiration", "must be YYYY-MM-DD or RFC 3339"}
+	}
+	m.Expiration = expiration
+	return nil
+}
+
+// Message of DeleteProductCar, with the version of the line that was seen
+type wsLineRefMessage struct {
+	ID      int `json:"id"`
+	Version int `json:"version"`
+}
+
+func (m *wsLineRefMessage) validate() error {
+	if m.ID <= 0 {
+		return &wsFieldError{"id", "is required"}
+	}
+	if m.Version <= 0 {
+		return &wsFieldError{"version", "of the line is required"}
+	}
+	return nil
+}
+
+// Message of Export, the token is the JWT of an admin and is only needed with override
+type wsExportMessage struct {
+	Override bool   `json:"override"`
+	Token    string `json:"token"`
+}
+
+// Message of Sync, with the seq of the last update the client applied
+type wsSyncMessage struct {
+	Seq int64 `json:"seq"`
+}
+
+// Message of SetCarSubtype, with the version of the car that was edited
+type wsSubtypeMessage struct {
+	Subtype string `json:"subtype"`
+	Version int    `json:"version"`
+}
+
+func (m *wsSubtypeMessage) validate() error {
+	if m.Subtype == "" {
+		return &wsFieldError{"subtype", "is required"}
+	}
+	if m.Version <= 0 {
+		return &wsFieldError{"version", "of the car is required"}
+	}
+	return nil
+}
+
+// Message of UpdateCarInfo, with the version of the car that was edited
+type wsInfoMessage struct {
+	database.CarMetadata
+	Version int `json:"version"`
+}
+
+func (m *wsInfoMessage) validate() error {
+	if m.Version <= 0 {
+		return &wsFieldError{"version", "of the car is required"}
+	}
+	return nil
+}
+
+// Puts the message into the struct of its action and checks the fields
+func decodeMessage(msg []byte, message interface{}) error {
+	if err := json.Unmarshal(msg, message); err != nil {
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) && typeErr.Field != "" {
+			return &wsFieldError{typeErr.Field, "must be " + jsonTypeName(typeErr.Type)}
+		}
+		return errInvalidMessage
+	}
+
+	if validator, ok := message.(interface{ validate() error }); ok {
+		return validator.validate()
+	}
+	return nil
+}
+
+// Name of the JSON type expected by a field, for the errors
+func jsonTypeName(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.String:
+		return "a string"
+	case reflect.Bool:
+		return "true or false"
+	case reflect.Int, reflect.Int64:
+		return "a whole number"
+	case reflect.Float64:
+		return "a number"
+	default:
+		return "an object"
+	}
+}
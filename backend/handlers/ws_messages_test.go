@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"errors"
+	"testing"
+)
+
+// Field of the error, empty when the message is valid or the error is not of a field
+func errorField(err error) string {
+	var fieldErr *wsFieldError
+	if errors.As(err, &fieldErr) {
+		return fieldErr.Field
+	}
+	return ""
+}
+
+func TestDecodeLineMessage(t *testing.T) {
+	tests := []struct {
+		name           string
+		msg            string
+		wantField      string
+		wantExpiration string
+	}{
+		{"new product", `{"id_product": "10", "quantity": 2}`, "", ""},
+		{"new product with a date", `{"id_product": "10", "quantity": 2, "expiration": "2026-05-10T00:00:00Z"}`, "", "2026-05-10"},
+		{"new product with a reason", `{"id_product": "10", "quantity": 2, "reason": "expired"}`, "", ""},
+		{"edit with the version", `{"id": 4, "version": 3, "quantity": 1.5}`, "", ""},
+		{"negative id", `{"id": -1, "version": 3, "quantity": 1}`, "id", ""},
+		{"new product without product", `{"quantity": 2}`, "id_product", ""},
+		{"edit without the version", `{"id": 4, "quantity": 2}`, "version", ""},
+		{"zero quantity", `{"id_product": "10", "quantity": 0}`, "quantity", ""},
+		{"negative quantity", `{"id_product": "10", "quantity": -3}`, "quantity", ""},
+		{"unknown reason", `{"id_product": "10", "quantity": 2, "reason": "lost"}`, "reason", ""},
+		{"bad date", `{"id_product": "10", "quantity": 2, "expiration": "10/05/2026"}`, "expiration", ""},
+		{"quantity as text", `{"id_product": "10", "quantity": "2"}`, "quantity", ""},
+		{"id as text", `{"id": "4", "version": 3, "quantity": 2}`, "id", ""},
+	}
+
+	for _, test := range tests {
+		var message wsLineMessage
+		err := decodeMessage([]byte(test.msg), &message)
+		if got := errorField(err); got != test.wantField {
+			t.Errorf("%s: error %v, want one on %q", test.name, err, test.wantField)
+		}
+		if err == nil && message.Expiration != test.wantExpiration {
+			t.Errorf("%s: expiration %q, want %q", test.name, message.Expiration, test.wantExpiration)
+		}
+	}
+}
+
+func TestDecodeMessageValidate(t *testing.T) {
+	tests := []struct {
+		name      string
+		msg       string
+		message   interface{}
+		wantField string
+	}{
+		{"envelope", `{"action": "GetCar", "request_id": "a1"}`, &wsEnvelope{}, ""},
+		{"envelope without action", `{"id_car": "abc123"}`, &wsEnvelope{}, "action"},
+		{"envelope with a number as action", `{"action": 3}`, &wsEnvelope{}, "action"},
+		{"delete", `{"id": 4, "version": 2}`, &wsLineRefMessage{}, ""},
+		{"delete without id", `{"version": 2}`, &wsLineRefMessage{}, "id"},
+		{"delete without version", `{"id": 4}`, &wsLineRefMessage{}, "version"},
+		{"subtype", `{"subtype": "expired", "version": 1}`, &wsSubtypeMessage{}, ""},
+		{"subtype without subtype", `{"version": 1}`, &wsSubtypeMessage{}, "subtype"},
+		{"subtype without version", `{"subtype": "expired"}`, &wsSubtypeMessage{}, "version"},
+		{"info", `{"id_donor": "D001", "version": 2}`, &wsInfoMessage{}, ""},
+		{"info without version", `{"id_donor": "D001"}`, &wsInfoMessage{}, "version"},
+		{"sync", `{"seq": 41}`, &wsSyncMessage{}, ""},
+		{"sync with text", `{"seq": "41"}`, &wsSyncMessage{}, "seq"},
+		{"export", `{"override": true, "token": "jwt"}`, &wsExportMessage{}, ""},
+		{"export with text override", `{"override": "yes"}`, &wsExportMessage{}, "override"},
+	}
+
+	for _, test := range tests {
+		err := decodeMessage([]byte(test.msg), test.message)
+		if got := errorField(err); got != test.wantField {
+			t.Errorf("%s: error %v, want one on %q", test.name, err, test.wantField)
+		}
+	}
+}
+
+func TestDecodeMessageInvalidJSON(t *testing.T) {
+	for _, msg := range []string{``, `not json`, `{"action": "GetCar"`, `[1, 2]`, `"GetCar"`} {
+		var envelope wsEnvelope
+		if err := decodeMessage([]byte(msg), &envelope); !errors.Is(err, errInvalidMessage) {
+			t.Errorf("decodeMessage(%q) = %v, want %v", msg, err, errInvalidMessage)
+		}
+	}
+}
@@ -8,44 +8,100 @@ import (
 	"time"
 
 	"github.com/Samuel-k276/backend/auth"
+	"github.com/Samuel-k276/backend/constants"
 	"github.com/Samuel-k276/backend/database"
 	"github.com/Samuel-k276/backend/handlers"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/rs/cors"
 )
 
-// Function that deletes outdated cars
-func deleteCars(db *pgxpool.Pool) {
-	fmt.Println("Cleaning the outdated cars")
-	database.DeleteCars(db)
+// Hour of the morning when the admins receive the expiry digest
+const expiryDigestHour = 8
+
+// Hour of the night when the cars past the legal retention are removed
+const purgeHour = 3
+
+// Function that moves the outdated cars to the archive
+func archiveCars(db *pgxpool.Pool) {
+	fmt.Println("Archiving the outdated cars")
+	archived, err := database.ArchiveCars(db, constants.GetArchiveDays())
+	if err != nil {
+		log.Println("Error archiving the cars:", err)
+		return
+	}
+	fmt.Println("Archived", archived, "cars")
 }
 
-func startScheduler(db *pgxpool.Pool) {
+// Function that removes the archived cars older than the legal retention
+func purgeCars(db *pgxpool.Pool) {
+	fmt.Println("Purging the cars past the retention")
+	purged, err := database.PurgeArchivedCars(db, constants.GetRetentionYears())
+	if err != nil {
+		log.Println("Error purging the cars:", err)
+		return
+	}
+	fmt.Println("Purged", purged, "cars")
+}
+
+// Function that flags, and later cancels, the cars nobody is using
+func cleanupStaleCars(db *pgxpool.Pool) {
+	fmt.Println("Checking the stale cars")
+	handlers.CleanupStaleCars(db)
+}
+
+// Function that sends the report of the expiring stock to the admins
+func sendExpiryDigest(db *pgxpool.Pool) {
+	fmt.Println("Sending the expiry digest")
+	handlers.BroadcastExpiryDigest(db)
+}
+
+// Runs the job every day at that hour
+func runDaily(loc *time.Location, hour int, name string, job func()) {
 	go func() {
-		fmt.Println("Scheduler goroutine started")
-		loc, err := time.LoadLocation("Europe/Lisbon")
-		if err != nil {
-			fmt.Printf("Error loading timezone, using UTC: %v\n", err)
-			loc = time.UTC
-		}
 		for {
 			now := time.Now().In(loc)
-			next := time.Date(now.Year(), now.Month(), now.Day(), 24, 0, 0, 0, loc)
+			next := time.Date(now.Year(), now.Month(), now.Day(), hour, 0, 0, 0, loc)
 
 			if now.After(next) || now.Equal(next) {
-				next = next.Add(24 * time.Hour)
+				next = next.AddDate(0, 0, 1)
 			}
 
 			duration := next.Sub(now)
-			fmt.Println("Waiting", duration, "until next execution at 12:00 PM")
+			fmt.Println("Waiting", duration, "until next", name, "at", next.Format("15:04"))
 
 			time.Sleep(duration)
 
-			deleteCars(db)
+			job()
+		}
+	}()
+}
+
+// Runs the job every interval, starting one interval from now
+func runEvery(interval time.Duration, name string, job func()) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		fmt.Println("Running", name, "every", interval)
+		for range ticker.C {
+			job()
 		}
 	}()
 }
 
+func startScheduler(db *pgxpool.Pool) {
+	fmt.Println("Scheduler goroutine started")
+	loc := constants.GetLocation()
+
+	// Archive at midnight, purge during the night and the digest in the morning
+	runDaily(loc, 0, "archive", func() { archiveCars(db) })
+	runDaily(loc, purgeHour, "purge", func() { purgeCars(db) })
+	runDaily(loc, expiryDigestHour, "expiry digest", func() { sendExpiryDigest(db) })
+
+	// The stale cars are checked every hour
+	runEvery(time.Hour, "stale cars check", func() { cleanupStaleCars(db) })
+}
+
 func main() {
 	// Initialize authentication
 	if err := auth.InitAuth(); err != nil {
@@ -78,11 +134,13 @@ func main() {
 	mux.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
 		handlers.HandleWebSocket(db, w, r)
 	})
+	// Register WebSocket handler of the admins
+	mux.HandleFunc("/ws/admin", handlers.HandleAdminWebSocket)
 	// Configure CORS
 	c := cors.New(cors.Options{
 		AllowedOrigins:   []string{"http://localhost:3000", "https://ajuda-de-berco.vercel.app", "https://*.run.app"},
-		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowedHeaders:   []string{"Content-Type", "Authorization", "Origin"},
+		AllowedMethods:   []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
+		AllowedHeaders:   []string{"Content-Type", "Authorization", "Origin", "X-Car-Token"},
 		AllowCredentials: true,
 		ExposedHeaders:   []string{"Access-Control-Allow-Origin"},
 	})
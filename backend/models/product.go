@@ -13,8 +13,10 @@ type Product struct {
 	Name           string    `json:"name"`
 	NormalizedName string    `json:"-"` // Campo não exportado para JSON
 	Unit           string    `json:"unit"`
-	PositionX      int       `json:"position_x"`
+	PositionX      int       `json:"position_x"` // A posição é a mesma no mapa de todos os armazéns
 	PositionY      int       `json:"position_y"`
+	MinQuantity    float64   `json:"min_quantity"`    // Abaixo desta quantidade o produto é uma necessidade
+	TargetQuantity float64   `json:"target_quantity"` // Quantidade a atingir quando se pede aos doadores
 	CreatedAt      time.Time `json:"created_at"`
 }
 
@@ -54,11 +56,36 @@ func NormalizeText(s string) string {
 	return sb.String()
 }
 
+// Categorias de produto, identificadas pelo início do código do produto
+var productCategories = []struct {
+	Prefix   string
+	Category string
+}{
+	{"GA", "ALIMENTAÇÃO"},
+	{"MTMT", "ESCRITÓRIO"},
+	{"PCPC", "PUERICULTURA"},
+	{"PH", "HIGIENE"},
+	{"FR", "FARMÁCIA"},
+	{"PL", "LIMPEZA"},
+	{"PZMC", "COZINHA"},
+	{"VAVA", "VÁRIOS"},
+}
+
+// ProductCategory devolve a categoria de um produto a partir do seu código
+func ProductCategory(id string) string {
+	for _, category := range productCategories {
+		if strings.HasPrefix(id, category.Prefix) {
+			return category.Category
+		}
+	}
+	return "OUTROS"
+}
+
 // GetProducts recupera todos os produtos do banco de dados
 func GetProducts(db *pgxpool.Pool) ([]Product, error) {
 	// Query to get all products
 	query := `
-		SELECT id_product, name, normalized_name, unit, pos_x, pos_y, created_at 
+		SELECT id_product, name, normalized_name, unit, pos_x, pos_y, min_quantity, target_quantity, created_at 
 		FROM products
 	`
 
@@ -71,7 +98,7 @@ func GetProducts(db *pgxpool.Pool) ([]Product, error) {
 	products := []Product{}
 	for rows.Next() {
 		var product Product
-		err := rows.Scan(&product.ID, &product.Name, &product.NormalizedName, &product.Unit, &product.PositionX, &product.PositionY, &product.CreatedAt)
+		err := rows.Scan(&product.ID, &product.Name, &product.NormalizedName, &product.Unit, &product.PositionX, &product.PositionY, &product.MinQuantity, &product.TargetQuantity, &product.CreatedAt)
 		if err != nil {
 			return nil, err
 		}
@@ -84,13 +111,13 @@ func GetProducts(db *pgxpool.Pool) ([]Product, error) {
 func GetProduct(db *pgxpool.Pool, id string) (Product, error) {
 	// Query to get a product by ID
 	query := `
-		SELECT id_product, name, normalized_name, unit, pos_x, pos_y, created_at 
+		SELECT id_product, name, normalized_name, unit, pos_x, pos_y, min_quantity, target_quantity, created_at 
 		FROM products 
 		WHERE id_product = $1
 	`
 
 	var product Product
-	err := db.QueryRow(context.Background(), query, id).Scan(&product.ID, &product.Name, &product.NormalizedName, &product.Unit, &product.PositionX, &product.PositionY, &product.CreatedAt)
+	err := db.QueryRow(context.Background(), query, id).Scan(&product.ID, &product.Name, &product.NormalizedName, &product.Unit, &product.PositionX, &product.PositionY, &product.MinQuantity, &product.TargetQuantity, &product.CreatedAt)
 	return product, err
 }
 
@@ -108,16 +135,16 @@ func CreateProduct(db *pgxpool.Pool, id, name, unit string) error {
 }
 
 // UpdateProduct atualiza um produto existente
-func UpdateProduct(db *pgxpool.Pool, id, name, unit string, positionX int, positionY int) error {
+func UpdateProduct(db *pgxpool.Pool, id, name, unit string, positionX int, positionY int, minQuantity float64, targetQuantity float64) error {
 	// Query to update a product
 	query := `
 		UPDATE products 
-		SET name = $1, normalized_name = $2, unit = $3, pos_x = $4, pos_y = $5
-		WHERE id_product = $6
+		SET name = $1, normalized_name = $2, unit = $3, pos_x = $4, pos_y = $5, min_quantity = $6, target_quantity = $7
+		WHERE id_product = $8
 	`
 
 	normalizedName := NormalizeText(name)
-	_, err := db.Exec(context.Background(), query, name, normalizedName, unit, positionX, positionY, id)
+	_, err := db.Exec(context.Background(), query, name, normalizedName, unit, positionX, positionY, minQuantity, targetQuantity, id)
 	return err
 }
 
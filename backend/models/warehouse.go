@@ -0,0 +1,107 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// ErrWarehouseInUse é devolvido quando um armazém ainda tem carrinhos ou movimentos de stock
+var ErrWarehouseInUse = errors.New("warehouse has cars or stock movements and can not be deleted")
+
+// Warehouse representa um armazém da associação
+type Warehouse struct {
+	ID             string    `json:"id"`
+	Name           string    `json:"name"`
+	NormalizedName string    `json:"-"` // Campo não exportado para JSON
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// GetWarehouses recupera todos os armazéns do banco de dados
+func GetWarehouses(db *pgxpool.Pool) ([]Warehouse, error) {
+	// Query to get all warehouses
+	query := `
+		SELECT id_warehouse, name, normalized_name, created_at 
+		FROM warehouses
+		ORDER BY name
+	`
+
+	rows, err := db.Query(context.Background(), query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	warehouses := []Warehouse{}
+	for rows.Next() {
+		var warehouse Warehouse
+		err := rows.Scan(&warehouse.ID, &warehouse.Name, &warehouse.NormalizedName, &warehouse.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		warehouses = append(warehouses, warehouse)
+	}
+	return warehouses, nil
+}
+
+// GetWarehouse recupera um armazém pelo ID
+func GetWarehouse(db *pgxpool.Pool, id string) (Warehouse, error) {
+	// Query to get a warehouse by ID
+	query := `
+		SELECT id_warehouse, name, normalized_name, created_at 
+		FROM warehouses 
+		WHERE id_warehouse = $1
+	`
+
+	var warehouse Warehouse
+	err := db.QueryRow(context.Background(), query, id).Scan(&warehouse.ID, &warehouse.Name, &warehouse.NormalizedName, &warehouse.CreatedAt)
+	return warehouse, err
+}
+
+// CreateWarehouse insere um novo armazém no banco de dados
+func CreateWarehouse(db *pgxpool.Pool, id, name string) error {
+	// Query to insert a new warehouse
+	query := `
+		INSERT INTO warehouses (id_warehouse, name, normalized_name) 
+		VALUES ($1, $2, $3)
+	`
+
+	normalizedName := NormalizeText(name)
+	_, err := db.Exec(context.Background(), query, id, name, normalizedName)
+	return err
+}
+
+// UpdateWarehouse atualiza um armazém existente
+func UpdateWarehouse(db *pgxpool.Pool, id, name string) error {
+	// Query to update a warehouse
+	query := `
+		UPDATE warehouses 
+		SET name = $1, normalized_name = $2
+		WHERE id_warehouse = $3
+	`
+
+	normalizedName := NormalizeText(name)
+	_, err := db.Exec(context.Background(), query, name, normalizedName, id)
+	return err
+}
+
+// DeleteWarehouse remove um armazém do banco de dados, só se nada o usar
+func DeleteWarehouse(db *pgxpool.Pool, id string) error {
+	// Query to delete a warehouse
+	query := `
+		DELETE FROM warehouses 
+		WHERE id_warehouse = $1
+	`
+
+	_, err := db.Exec(context.Background(), query, id)
+
+	// Os carrinhos e os movimentos de stock apontam para o armazém
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23503" {
+		return ErrWarehouseInUse
+	}
+	return err
+}
@@ -1,5 +1,72 @@
 import type { Cart } from '../types/carts';
-import { API_BASE_URL, CARTS_ENDPOINTS } from '../constants';
+import { CARTS_ENDPOINTS, STORAGE_KEYS } from '../constants';
+import { getAuthToken } from './auth';
+
+/**
+ * Gets the access tokens of the carts created or joined on this device
+ */
+const getCartTokens = (): Record<string, string> => {
+  try {
+    return JSON.parse(localStorage.getItem(STORAGE_KEYS.CART_TOKENS) || "{}");
+  } catch {
+    return {};
+  }
+};
+
+/**
+ * Keeps the access token of a cart so the cart can be opened again on this device
+ * @param id Cart ID
+ * @param token Access token of the cart
+ */
+export const saveCartToken = (id: string, token: string): void => {
+  const tokens = getCartTokens();
+  tokens[id] = token;
+  localStorage.setItem(STORAGE_KEYS.CART_TOKENS, JSON.stringify(tokens));
+};
+
+/**
+ * Gets the access token of a cart, or the JWT of the logged in user that opens every cart
+ * @param id Cart ID
+ * @returns The token, or an empty string without access
+ */
+export const getCartToken = (id: string): string => {
+  return getCartTokens()[id] || getAuthToken() || "";
+};
+
+/**
+ * Code shared with the other volunteers to join the cart, the ID followed by the access token
+ * @param id Cart ID
+ */
+export const getCartShareCode = (id: string): string => {
+  const token = getCartTokens()[id];
+  return token ? `${id}-${token}` : id;
+};
+
+/**
+ * Reads a code typed to join a cart, it can be the share code or only the ID of a cart opened before
+ * @param code Code typed by the user
+ * @returns The ID of the cart, the token is kept for the next requests
+ */
+export const parseCartCode = (code: string): string => {
+  const [id, token] = code.trim().toUpperCase().split("-");
+  if (token) {
+    saveCartToken(id, token.toLowerCase());
+  }
+  return id;
+};
+
+/**
+ * Headers that give access to a cart
+ * @param id Cart ID
+ */
+const cartAuthHeaders = (id: string): Record<string, string> => {
+  const token = getCartTokens()[id];
+  if (token) {
+    return { 'X-Car-Token': token };
+  }
+  const jwt = getAuthToken();
+  return jwt ? { 'Authorization': `Bearer ${jwt}` } : {};
+};
 
 /**
  * Creates a new cart in the system
@@ -26,7 +93,9 @@ export const createCar = async (
     }
 
     const data = await response.json();
-    return {id: data.id_car, type: data.type, products: [], exportedAt: ""}
+    // The token is only sent when the cart is created
+    saveCartToken(data.id_car, data.access_token);
+    return {id: data.id_car, type: data.type, products: [], exportedAt: "", accessToken: data.access_token}
   } catch (error) {
     console.error('Error creating cart:', error);
     throw error;
@@ -46,6 +115,7 @@ export const getCart = async (
       method: 'GET',
       headers: {
         'Content-Type': 'application/json',
+        ...cartAuthHeaders(id),
       },
     });
 
@@ -94,116 +164,3 @@ export const getAllCarts = async (
     throw error;
   }
 }
-
-
-/**
- * Adds a product to a cart
- * @param carId Cart ID
- * @param productId Product ID
- * @param quantity Quantity of the product
- * @param expirationDate Product expiration date in ISO 8601 format
- * @returns Updated cart
- */
-export const addProductToCart = async (
-  carId: string,
-  productId: string,
-  quantity: number,
-  expirationDate: string
-): Promise<Cart> => {
-  try {
-    const response = await fetch(`${API_BASE_URL}/cars/add-product?id=${carId}`, {
-      method: 'POST',
-      headers: {
-        'Content-Type': 'application/json',
-      },
-      body: JSON.stringify({
-        product_id: productId,
-        quantity,
-        expiration_date: expirationDate,
-      }),
-    });
-
-    if (!response.ok) {
-      throw new Error(`Error adding product: ${response.status}`);
-    }
-
-    return await response.json() as Cart;
-  } catch (error) {
-    console.error('Error adding product to cart:', error);
-    throw error;
-  }
-};
-
-/**
- * Removes a product from a cart
- * @param carId Cart ID
- * @param productId Product ID
- * @param expirationDate Optional expiration date (if provided, removes only products with this date)
- * @returns Updated cart
- */
-export const removeProductFromCart = async (
-  carId: string, 
-  productId: string, 
-  expirationDate?: string
-): Promise<Cart> => {
-  try {
-    let url = `${API_BASE_URL}/cars/remove-product?car_id=${carId}&product_id=${productId}`;
-    
-    if (expirationDate) {
-      url += `&expiration_date=${encodeURIComponent(expirationDate)}`;
-    }
-    
-    const response = await fetch(url, {
-      method: 'DELETE',
-      headers: {
-        'Content-Type': 'application/json',
-      },
-    });
-
-    if (!response.ok) {
-      throw new Error(`Error removing product: ${response.status}`);
-    }
-
-    return await response.json() as Cart;
-  } catch (error) {
-    console.error('Error removing product from cart:', error);
-    throw error;
-  }
-};
-
-/**
- * Updates the quantity of a product in a cart
- * @param carId Cart ID
- * @param productId Product ID
- * @param quantity New quantity
- * @param expirationDate Product expiration date
- * @returns Updated cart
- */
-export const updateProductQuantity = async (
-  carId: string, 
-  productId: string, 
-  quantity: number,
-  expirationDate: string
-): Promise<Cart> => {
-  try {
-    const response = await fetch(`${API_BASE_URL}/cars/update-quantity?car_id=${carId}&product_id=${productId}`, {
-      method: 'PUT',
-      headers: {
-        'Content-Type': 'application/json',
-      },
-      body: JSON.stringify({
-        quantity,
-        expiration_date: expirationDate,
-      }),
-    });
-
-    if (!response.ok) {
-      throw new Error(`Error updating quantity: ${response.status}`);
-    }
-
-    return await response.json() as Cart;
-  } catch (error) {
-    console.error('Error updating product quantity:', error);
-    throw error;
-  }
-};
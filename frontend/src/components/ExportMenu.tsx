@@ -349,18 +349,58 @@ const ExportMenu: React.FC<ExportMenuProps> = ({ isOpen, onClose, products, cart
         })
       }
 
-      // Save document
-      doc.save(getFileNameWithTimestamp("pdf"))
-
-      // Send socket message and close
-      socket.send(
-        JSON.stringify({
-          action: "Export",
-          id_car: id_car,
-        }),
+      // O PDF só é guardado depois de o servidor aceitar a exportação
+      sendExport().then((reply) => {
+        if (reply.action === "Ack") {
+          doc.save(getFileNameWithTimestamp("pdf"))
+          onClose()
+        } else if (reply.action === "ExportError") {
+          alert(describeExportError(reply))
+        }
+      })
+    }
+  }
+
+  // Envia o Export e espera pela resposta com o mesmo request_id (Ack, ExportError ou Error)
+  const sendExport = (): Promise<any> => {
+    if (socket.readyState !== WebSocket.OPEN) {
+      alert("Sem ligação ao servidor, não foi possível exportar.")
+      return Promise.resolve({ action: "Error" })
+    }
+
+    const requestId = `export-${Date.now()}`
+    return new Promise((resolve) => {
+      const handleReply = (event: MessageEvent) => {
+        try {
+          const data = JSON.parse(event.data)
+          if (data.request_id === requestId && ["Ack", "ExportError", "Error"].includes(data.action)) {
+            socket.removeEventListener("message", handleReply)
+            resolve(data)
+          }
+        } catch (error) {
+          console.error("Failed to parse WebSocket message:", error)
+        }
+      }
+      socket.addEventListener("message", handleReply)
+      socket.send(JSON.stringify({ action: "Export", id_car: id_car, request_id: requestId }))
+    })
+  }
+
+  // Explica ao voluntário porque é que o carrinho não foi exportado
+  const describeExportError = (reply: any): string => {
+    const productName = (code: string) => products.find((product) => product.code === code)?.name || code
+
+    if (reply.shortages?.length) {
+      const lines = reply.shortages.map((shortage: any) =>
+        `- ${productName(shortage.id_product)}${shortage.expiration ? ` (validade ${shortage.expiration})` : ""}: pedido ${shortage.requested}, em stock ${shortage.available}`
       )
-      onClose()
+      return `O carrinho não foi exportado, não há stock suficiente:\n${lines.join("\n")}`
+    }
+    if (reply.lines?.length) {
+      const names = products.filter((product) => reply.lines.includes(product.id)).map((product) => product.name)
+      return `O carrinho não foi exportado, há produtos sem motivo: ${names.join(", ") || reply.lines.join(", ")}`
     }
+    return `O carrinho não foi exportado: ${reply.error}`
   }
 
   const handleInitialStep = () => {
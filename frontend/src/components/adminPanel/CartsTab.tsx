@@ -19,7 +19,7 @@ const DeleteCartButton: React.FC<{
   showText?: boolean;
 }> = ({ cartId, onDelete, showText = false }) => {
   const handleDelete = () => {
-    const socket = new WebSocket(WEBSOCKET_ENDPOINTS.CONNECT(cartId));
+    const socket = new WebSocket(WEBSOCKET_ENDPOINTS.CONNECT(cartId, "", getAuthToken()));
     socket.onopen = () => {
       socket.send(JSON.stringify({
         action: WS_ACTIONS.DELETE_CAR,
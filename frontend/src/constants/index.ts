@@ -84,7 +84,7 @@ export const DONORS_ENDPOINTS = {
  */
 export const CARTS_ENDPOINTS = {
   GET_ALL: `${API_BASE_URL}/cars`,
-  GET_BY_ID: (id: string) => `${API_BASE_URL}/cars/get?id=${id}`,
+  GET_BY_ID: (id: string) => `${API_BASE_URL}/cars/${id}`,
   CREATE: `${API_BASE_URL}/cars/create`,
 };
 
@@ -104,7 +104,10 @@ export const WS_ACTIONS = {
  * WebSocket Functions
  */
 export const WEBSOCKET_ENDPOINTS = {
-  CONNECT: (carId: string) => `${WEBSOCKET_URL}/ws?id_car=${carId}`,
+  // The token is the access token of the cart, the JWT of a logged in user goes apart and gives the role
+  CONNECT: (carId: string, token: string, jwt?: string | null) =>
+    `${WEBSOCKET_URL}/ws?id_car=${carId}&token=${encodeURIComponent(token)}` +
+    (jwt ? `&jwt=${encodeURIComponent(jwt)}` : ""),
 };
 
 /**
@@ -123,6 +126,7 @@ export const STORAGE_KEYS = {
   AUTH_TOKEN: 'authToken',
   AUTH_ROLE: 'authRole',
   CURRENT_CART: 'currentCart',
+  CART_TOKENS: 'cartTokens',
 };
 
 /**
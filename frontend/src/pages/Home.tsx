@@ -1,6 +1,6 @@
 import React, { useState } from "react"
 import { useNavigate } from "react-router-dom";
-import { getCart } from "../api/carts";
+import { getCart, parseCartCode } from "../api/carts";
 import "./Home.css"
 
 const Home: React.FC = () => {
@@ -15,16 +15,19 @@ const Home: React.FC = () => {
     setIsLoading(true);
     setCode(code.trim());
 
+    // The code is the one shared by whoever created the cart, or only the ID of a cart opened on this device
+    const id = parseCartCode(code);
+
     // Validate the code
-    if (code.length !== 6) {
-      setErrorMessage("O código deve ter 6 caracteres.");
+    if (id.length !== 6) {
+      setErrorMessage("O código do carrinho deve começar por 6 caracteres.");
       setIsLoading(false);
       return;
     }
 
     // Fetch the cart using the code
     try {
-      const car = await getCart(code);
+      const car = await getCart(id);
       if (car && car.id) {
         console.log("Carrinho encontrado:", car);
         // Navigate to the MyCart page with the cart ID and type
@@ -40,7 +43,11 @@ const Home: React.FC = () => {
       }
     } catch (error) {
       console.error("Erro ao procurar carrinho:", error);
-      setErrorMessage("Ocorreu um erro ao procurar o carrinho. Tente novamente.");
+      setErrorMessage(
+        (error instanceof Error && error.message.includes("401"))
+          ? "Sem acesso ao carrinho. Use o código completo partilhado por quem o criou."
+          : "Ocorreu um erro ao procurar o carrinho. Tente novamente."
+      );
     } finally {
       setIsLoading(false);
     }
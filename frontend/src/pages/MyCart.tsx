@@ -4,12 +4,27 @@ import { Trash2, Edit, Copy } from "lucide-react";
 import { ProductInCart } from "../types/carts";
 import type { Product } from "../types/product";
 import { getProductById } from "../api/products";
+import { getCartShareCode, getCartToken } from "../api/carts";
+import { getAuthToken } from "../api/auth";
 import { ASSETS, WEBSOCKET_ENDPOINTS } from "../constants/index";
 import ExportMenu from "../components/ExportMenu";
 import SearchBar from "../components/SearchBar";
 import ProductMap from "../components/adminPanel/ProductMap";
 import "./MyCart.css";
 
+// Linha do carrinho como vem do servidor
+const toProductInCart = (product: any): ProductInCart => ({
+  id: product.id,
+  code: product.id_product,
+  name: product.name,
+  unit: product.unit,
+  quantity: product.quantity,
+  description: product.description,
+  expirationDate: product.expiration,
+  reason: product.reason,
+  version: product.version,
+});
+
 const MyCart: React.FC = () => {
   const navigate = useNavigate();
   const location = useLocation();
@@ -35,13 +50,28 @@ const MyCart: React.FC = () => {
   const [productToMap, setProductToMap] = useState<Product | null>(null);
   const [copied, setCopied] = useState(false);
 
+  // The code copied has the access token, so whoever gets it can join the cart
   const handleCopy = () => {
-    navigator.clipboard.writeText(`${id_cart}`);
+    navigator.clipboard.writeText(getCartShareCode(id_cart));
     setCopied(true);
     setTimeout(() => setCopied(false), 2000);
   };
 
   const socketRef = useRef<WebSocket | null>(null);
+  // Último seq aplicado, os CarDiff só se aplicam sobre ele
+  const seqRef = useRef<number | null>(null);
+  // Contador dos request_id das mensagens enviadas
+  const requestCounterRef = useRef(0);
+
+  const newRequestId = () => {
+    requestCounterRef.current += 1;
+    return `${id_cart}-${requestCounterRef.current}`;
+  };
+
+  // Tira a linha ainda por gravar do pedido, a linha gravada chega pelo servidor
+  const dropPendingLine = (requestId: string) => {
+    setProducts((current) => current.filter((product) => product.requestId !== requestId));
+  };
 
   const requestCart = () => {
     if (socketRef.current && socketRef.current.readyState === WebSocket.OPEN) {
@@ -60,7 +90,8 @@ const MyCart: React.FC = () => {
       navigate(-1);
     }
     let isMounted = true;
-    const socket = new WebSocket(WEBSOCKET_ENDPOINTS.CONNECT(id_cart));
+    seqRef.current = null;
+    const socket = new WebSocket(WEBSOCKET_ENDPOINTS.CONNECT(id_cart, getCartToken(id_cart), getAuthToken()));
     socketRef.current = socket;
 
     socket.onopen = () => {
@@ -75,21 +106,97 @@ const MyCart: React.FC = () => {
         const data = JSON.parse(event.data);
         console.log("Message received:", data);
 
+        // O carrinho foi apagado por outra pessoa
+        if (data.action === "CarDeleted" && data.id_car === id_cart) {
+          alert("Este carrinho foi apagado.");
+          navigate("/");
+          return;
+        }
+
         // Verificar se a mensagem é de atualização do carrinho
         if (data.action === "UpdateCar" && data.id_car === id_cart) {
-          // Atualizar os produtos no estado do carrinho com os dados recebidos
+          // O carrinho inteiro substitui o que havia, menos as linhas ainda por gravar
           console.log("Updating products list:", data.products);
-          setProducts(data.products ?
-            data.products.map((product: any) => ({
-              id: product.id,
-              code: product.id_product,
-              name: product.name,
-              unit: product.unit,
-              quantity: product.quantity,
-              description: product.description,
-              expirationDate: product.expiration,
-            })) : []
+          seqRef.current = data.seq;
+          setProducts((current) => [
+            ...(data.products ? data.products.map(toProductInCart) : []),
+            ...current.filter((product) => product.id === 0),
+          ]);
+          return;
+        }
+
+        // Só o que mudou desde o último seq
+        if (data.action === "CarDiff" && data.id_car === id_cart) {
+          // Já aplicado
+          if (seqRef.current !== null && data.seq <= seqRef.current) {
+            return;
+          }
+          // Faltam atualizações, o servidor envia as que faltam
+          if (data.base_seq !== seqRef.current) {
+            sendMessage({ action: "Sync", id_car: id_cart, seq: seqRef.current ?? 0 });
+            return;
+          }
+
+          seqRef.current = data.seq;
+          const updated = new Map<number, ProductInCart>(
+            (data.updated ?? []).map((product: any) => [product.id, toProductInCart(product)])
           );
+          const removed = new Set<number>(data.removed ?? []);
+          const added: ProductInCart[] = (data.added ?? []).map(toProductInCart);
+          // As linhas ainda por gravar (id 0) ficam no fim, até à resposta do seu pedido
+          setProducts((current) => [
+            ...current
+              .filter((product) => product.id !== 0 && !removed.has(product.id))
+              .map((product) => updated.get(product.id) ?? product),
+            ...added,
+            ...current.filter((product) => product.id === 0),
+          ]);
+          return;
+        }
+
+        // A alteração foi feita sobre uma versão antiga, fica o que está agora no servidor
+        if (data.action === "Conflict" && data.id_car === id_cart) {
+          if (data.id !== undefined) {
+            setProducts((current) => {
+              const others = current.filter((product) => product.id !== data.id);
+              return data.line ? [...others, toProductInCart(data.line)] : others;
+            });
+          }
+          alert("Outra pessoa alterou este produto entretanto. Foi carregado o que está agora no carrinho, volte a fazer a alteração.");
+          return;
+        }
+
+        // Numa saída o servidor diz que lotes retirar primeiro (FEFO)
+        if (data.action === "PickSuggestion" && data.id_car === id_cart) {
+          const notes: string[] = [];
+          const lots = (data.lots ?? []).filter((lot: any) => lot.expiration);
+          if (lots.length > 0) {
+            notes.push("Retire primeiro os lotes com validade " +
+              lots.map((lot: any) => `${lot.expiration} (${lot.quantity})`).join(", ") + ".");
+          }
+          if (data.missing > 0) {
+            notes.push(`O stock não cobre ${data.missing} unidade(s), que ficaram numa linha sem validade.`);
+          }
+          if ((data.expired ?? []).length > 0) {
+            notes.push("Há lotes fora de validade na prateleira para retirar: " +
+              data.expired.map((lot: any) => `${lot.expiration} (${lot.quantity})`).join(", ") + ".");
+          }
+          if (notes.length > 0) {
+            alert(notes.join("\n"));
+          }
+          return;
+        }
+
+        // A linha pedida já chegou num CarDiff (nova ou junta a uma que já existia)
+        if (data.action === "Ack" && data.id_car === id_cart) {
+          dropPendingLine(data.request_id);
+          return;
+        }
+
+        if (data.action === "Error" && data.id_car === id_cart) {
+          alert("Não foi possível fazer a alteração: " + data.error);
+          dropPendingLine(data.request_id);
+          requestCart();
         }
       } catch (error) {
         console.error("Failed to parse WebSocket message:", error);
@@ -306,10 +413,31 @@ const MyCart: React.FC = () => {
                     if (selectedProductIndex !== null) {
                       const productToSend = products[selectedProductIndex];
 
-                      const message = {
-                        action: "AddProductCar",
+                      // Uma linha nova já enviada espera pela resposta, não é enviada outra vez
+                      if (productToSend.id === 0 && productToSend.requestId) {
+                        setShowPopup(false);
+                        return;
+                      }
+                      const requestId = newRequestId();
+                      if (productToSend.id === 0) {
+                        updateProduct(selectedProductIndex, "requestId", requestId);
+                      }
+
+                      // Uma linha que já existe é editada sobre a versão que foi vista
+                      const message = productToSend.id > 0 ? {
+                        action: "EditProductCar",
                         id_car: id_cart,
+                        request_id: requestId,
                         id: productToSend.id,
+                        version: productToSend.version,
+                        quantity: productToSend.quantity,
+                        expiration: productToSend.expirationDate || "",
+                        description: productToSend.description || "",
+                        reason: productToSend.reason || "",
+                      } : {
+                        action: "AddProductCar",
+                        id_car: id_cart,
+                        request_id: requestId,
                         id_product: productToSend.code || "",
                         quantity: productToSend.quantity,
                         expiration: productToSend.expirationDate || "", // força string
@@ -411,10 +539,17 @@ const MyCart: React.FC = () => {
                               // Finding the product we want to send
                               const productToSend = products[index];
 
+                              // Uma linha ainda por gravar só existe aqui
+                              if (productToSend.id === 0) {
+                                deleteProduct(index);
+                                return;
+                              }
+
                               const message = {
                                 action: "DeleteProductCar",
                                 id_car: id_cart,
                                 id: productToSend.id,
+                                version: productToSend.version,
                               };
 
                               if (
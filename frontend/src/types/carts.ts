@@ -8,6 +8,10 @@ export interface ProductInCart {
    unit: string;
    expirationDate: string;
    description: string;
+   reason?: string;
+   version?: number;
+   // request_id do AddProductCar de uma linha ainda por gravar
+   requestId?: string;
 }
 
 export interface Cart {
@@ -15,4 +19,5 @@ export interface Cart {
    type: "Entrada" | "Saída";
    products: ProductInCart[];
    exportedAt: string;
+   accessToken?: string;
 }